@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	// "strings"
@@ -17,13 +16,13 @@ const usage_line = `export -s roof -o direcotry
 const short_desc = "export data to local file"
 
 var (
-	cfgDir string
-	roof   string
-	edir   string
-	eid    string
-	etotal int
-	elimit int
-	eskip  int
+	cfgFile string
+	roof    string
+	edir    string
+	eid     string
+	etotal  int
+	elimit  int
+	eskip   int
 )
 
 const (
@@ -33,11 +32,11 @@ const (
 func usage() {
 	fmt.Printf("Usage: \t%s\nDefault Usage:\n", usage_line)
 	flag.PrintDefaults()
-	fmt.Println("\nDescription:\n   " + short_desc + "\n")
+	fmt.Print("\nDescription:\n   " + short_desc + "\n\n")
 }
 
 func init() {
-	flag.StringVar(&cfgDir, "conf", "/etc/imsto", "app conf dir")
+	flag.StringVar(&cfgFile, "conf", "", "config file (YAML)")
 	flag.StringVar(&roof, "s", "", "config section name")
 	flag.StringVar(&edir, "o", "", "a local direcotry to export into.")
 	flag.StringVar(&eid, "id", "", "only export a special id.")
@@ -46,25 +45,17 @@ func init() {
 	flag.IntVar(&eskip, "skip", 0, "offset.")
 
 	flag.Parse()
-	if cfgDir != "" {
-		config.SetRoot(cfgDir)
-	}
-
-	config.AtLoaded(func() error {
-		return config.SetLogFile("export")
-	})
-
-	err := config.Load()
-	if err != nil {
-		log.Print("config load error: ", err)
-		os.Exit(1)
+	if cfgFile != "" {
+		if err := config.Load(cfgFile); err != nil {
+			fmt.Println("config load error: ", err)
+			os.Exit(1)
+		}
 	}
-
 }
 
 func main() {
 	// fmt.Printf("roof: %s, edir: %s\n", roof, edir)
-	if roof == "" || config.Root() == "" || edir == "" {
+	if roof == "" || edir == "" {
 		usage()
 		return
 	}
@@ -85,7 +76,8 @@ func main() {
 			fmt.Printf("get entry error: %s", err)
 			return
 		}
-		_save_export(entry.Path, entry.Size, edir)
+		_save_export(eid, entry.Path, entry.Size, edir)
+		return
 	}
 
 	filter := storage.MetaFilter{}
@@ -118,7 +110,7 @@ func main() {
 		}
 
 		for _, entry := range a {
-			if !_save_export(entry.Path, entry.Size, edir) {
+			if !_save_export(entry.Id.String(), entry.Path, entry.Size, edir) {
 				return
 			}
 		}
@@ -128,14 +120,14 @@ func main() {
 	return
 }
 
-func _save_export(key string, size uint32, edir string) bool {
+func _save_export(id, key string, size uint32, edir string) bool {
 	name := path.Join(edir, key)
 	fmt.Printf("save to: %s ", name)
 	if fi, fe := os.Stat(name); fe == nil && fi.Size() == int64(size) {
 		fmt.Println("exist")
 		return true
 	}
-	err := storage.Dump(key, roof, name)
+	err := storage.Dump(roof, id, name)
 	if err != nil {
 		fmt.Println(err)
 		return false
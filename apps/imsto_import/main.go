@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -125,13 +124,13 @@ func _store_zip(zipfile string) bool {
 		name := _shrink_name(f.Name)
 
 		var buf []byte
-		buf, err = ioutil.ReadAll(rc)
+		buf, err = storage.ReadLimited(rc, config.GetSection(roof).UploadLimit())
+		rc.Close()
 		if err != nil {
-			log.Print(err)
-			return false
+			log.Printf("read %s: %s", name, err)
+			continue
 		}
-		rc.Close()
-		entry, err := storage.PrepareReader(bytes.NewReader(buf), name)
+		entry, err := storage.PrepareReader(roof, bytes.NewReader(buf), name)
 		if err != nil {
 			log.Print(err)
 			continue
@@ -189,7 +188,7 @@ func _store_file(file, roof, tag string) {
 	}
 
 	// fmt.Printf("%s\n", name)
-	entry, err := storage.PrepareFile(file, name)
+	entry, err := storage.PrepareFile(roof, file, name)
 	if err != nil {
 		log.Printf("prepare file error: %s", err)
 		return
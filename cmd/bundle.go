@@ -17,6 +17,7 @@ func init() {
 }
 
 func runBundle(args []string) bool {
+	watchReload()
 	fmt.Printf("Start RPC/Stage/Tiring service %s\n", config.Version)
 	go runTiring(args)
 	go runStage(args)
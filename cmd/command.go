@@ -36,6 +36,16 @@ func (cmd *Command) Name() string {
 	return name
 }
 
+// IsSet 命令行是否给出了 name 参数
+func (cmd *Command) IsSet(name string) (set bool) {
+	cmd.Flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
+
 func (cmd *Command) Usage() {
 	fmt.Fprintf(os.Stderr, "Usage: imsto %s\n", cmd.UsageLine)
 	fmt.Fprintf(os.Stderr, "Default Usage:\n")
@@ -57,6 +67,9 @@ var commands = []*Command{
 	// cmdExport,
 	// cmdOptimize,
 	cmdFetch,
+	cmdGC,
+	cmdReconcile,
+	cmdScrub,
 	cmdRPC,
 	cmdTiring,
 	cmdStage,
@@ -77,9 +90,19 @@ func logger() zlog.Logger {
 	return zlog.Get()
 }
 
+var confFile string
+
 func init() {
+	flag.StringVar(&confFile, "conf", "", "config file (YAML), env IMSTO_CONFIG")
 	flag.Parse()
 
+	if confFile != "" {
+		if err := config.Load(confFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	storage.InitMetaTables()
 }
 
@@ -115,8 +138,8 @@ func Main() {
 
 	zlog.Set(sugar)
 
-	if config.Current.SentryDSN != "" {
-		raven.SetDSN(config.Current.SentryDSN)
+	if config.Current().SentryDSN != "" {
+		raven.SetDSN(config.Current().SentryDSN)
 	}
 
 	for _, cmd := range commands {
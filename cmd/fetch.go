@@ -1,16 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-imsto/imsto/storage"
+	"github.com/go-imsto/imsto/storage/fetcher"
 )
 
 var cmdFetch = &Command{
-	UsageLine: "fetch -uri URI -roof ROOF",
+	UsageLine: "fetch -roof ROOF -uri URI [-revalidate] | -list FILE [-journal FILE]",
 	Short:     "fetch a image from URI",
 	Long: `
-fetch a image from URI
+fetch a image from URI, or many from a list file (- for stdin),
+every line of the list is an URI or a JSON object like {"uri":"...","referer":"...","tags":["..."]},
+one JSON result line is printed for every URI, it is appended to the journal too,
+URIs which succeeded in the journal are skipped when run again
 `,
 }
 
@@ -18,6 +30,14 @@ var (
 	fetchRoof  = cmdFetch.Flag.String("roof", "", "roof")
 	fetchURI   = cmdFetch.Flag.String("uri", "", "A imgae uri")
 	fetchRefer = cmdFetch.Flag.String("refer", "", "http referer")
+	fetchReval = cmdFetch.Flag.Bool("revalidate", false, "check a fetched uri again with a conditional request")
+
+	fetchList     = cmdFetch.Flag.String("list", "", "file of uris, - for stdin")
+	fetchJournal  = cmdFetch.Flag.String("journal", "", "file of results for resuming")
+	fetchWorkers  = cmdFetch.Flag.Int("c", 4, "concurrency")
+	fetchRetries  = cmdFetch.Flag.Int("retries", 3, "retries of a temporary failure")
+	fetchBackoff  = cmdFetch.Flag.Duration("backoff", time.Second, "first delay of retry, doubled every time")
+	fetchInterval = cmdFetch.Flag.Duration("host-interval", 200*time.Millisecond, "min interval of requests to the same host")
 )
 
 func init() {
@@ -25,11 +45,18 @@ func init() {
 }
 
 func runFetch(args []string) bool {
-	if *fetchRoof == "" || *fetchURI == "" {
+	if *fetchRoof == "" {
+		return false
+	}
+	if *fetchList != "" {
+		runFetchList()
+		return true
+	}
+	if *fetchURI == "" {
 		return false
 	}
 	fmt.Printf("Fetching into %s from %s\n", *fetchRoof, *fetchURI)
-	in := storage.FetchInput{URI: *fetchURI, Roof: *fetchRoof}
+	in := storage.FetchInput{URI: *fetchURI, Roof: *fetchRoof, Revalidate: *fetchReval}
 	if *fetchRefer != "" {
 		in.Referer = *fetchRefer
 	}
@@ -41,3 +68,104 @@ func runFetch(args []string) bool {
 	fmt.Println("new entry ", entry.Path)
 	return true
 }
+
+func runFetchList() {
+	var in io.Reader = os.Stdin
+	if *fetchList != "-" {
+		f, err := os.Open(*fetchList)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			setExitStatus(1)
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+
+	done := map[string]bool{}
+	var journal *os.File
+	if *fetchJournal != "" {
+		var err error
+		if f, err := os.Open(*fetchJournal); err == nil {
+			done, err = fetcher.LoadJournal(f)
+			f.Close()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				setExitStatus(1)
+				return
+			}
+		}
+		journal, err = os.OpenFile(*fetchJournal, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			setExitStatus(1)
+			return
+		}
+		defer journal.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobs := make(chan fetcher.Job)
+	go func() {
+		defer close(jobs)
+		sc := bufio.NewScanner(in)
+		for sc.Scan() {
+			job, ok, err := fetcher.ParseJob(sc.Text())
+			if err != nil {
+				logger().Warnw("bad line", "line", sc.Text(), "err", err)
+				setExitStatus(1)
+				continue
+			}
+			if !ok || done[job.URI] {
+				continue
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			logger().Warnw("read list fail", "err", err)
+			setExitStatus(1)
+		}
+	}()
+
+	b := &fetcher.Batch{
+		Concurrency:  *fetchWorkers,
+		Retries:      *fetchRetries,
+		Backoff:      *fetchBackoff,
+		HostInterval: *fetchInterval,
+		Do: func(ctx context.Context, job fetcher.Job) (id, path string, err error) {
+			entry, err := storage.Fetch(storage.FetchInput{
+				URI:     job.URI,
+				Roof:    *fetchRoof,
+				Referer: job.Referer,
+				Tags:    job.Tags,
+
+				Revalidate: *fetchReval,
+			})
+			if err != nil {
+				return
+			}
+			return entry.Id.String(), entry.Path, nil
+		},
+	}
+	var ok, failed int
+	enc := json.NewEncoder(os.Stdout)
+	b.Run(ctx, jobs, func(o fetcher.Outcome) {
+		if o.Error == "" {
+			ok++
+		} else {
+			failed++
+			setExitStatus(1)
+		}
+		_ = enc.Encode(o)
+		if journal != nil {
+			_ = json.NewEncoder(journal).Encode(o)
+		}
+	})
+	logger().Infow("fetch list done", "ok", ok, "failed", failed)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage"
+)
+
+var cmdGC = &Command{
+	UsageLine: "gc [-grace 168h] [-limit 1000] [-dry-run]",
+	Short:     "remove files of deleted entries",
+	Long: `
+remove files of entries which are deleted from every roof for longer than the grace period,
+from the storage engine and the local cache (originals and thumbnails under cache_root/thumb),
+one JSON line is printed for every entry, and a summary at last,
+-dry-run only reports what would be removed
+`,
+}
+
+var (
+	gcGrace  = cmdGC.Flag.Duration("grace", 0, "keep entries deleted in this period, default is gc_grace of config")
+	gcLimit  = cmdGC.Flag.Int("limit", storage.DefaultGCLimit, "max entries, 0 is no limit")
+	gcDryRun = cmdGC.Flag.Bool("dry-run", false, "report only")
+)
+
+func init() {
+	cmdGC.Run = runGC
+}
+
+func runGC(args []string) bool {
+	// 配置在参数定义之后才载入，这里再读
+	grace := config.Current().GCGrace
+	if cmdGC.IsSet("grace") {
+		grace = *gcGrace
+	}
+	if grace < 0 {
+		return false
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	rep, err := storage.GC(ctx, grace, *gcLimit, *gcDryRun, func(g *storage.Garbage) {
+		_ = enc.Encode(g)
+	})
+	if rep != nil {
+		_ = enc.Encode(rep)
+		if rep.Failed > 0 {
+			setExitStatus(1)
+		}
+	}
+	if err != nil {
+		logger().Warnw("gc fail", "err", err)
+		setExitStatus(1)
+	}
+	return true
+}
+
+var gcOnce sync.Once
+
+// scheduleGC 按 gc_interval 定时执行 gc，为 0 时不执行
+func scheduleGC() {
+	gcOnce.Do(func() {
+		if config.Current().GCInterval <= 0 {
+			return
+		}
+		go func() {
+			tk := time.NewTicker(config.Current().GCInterval)
+			defer tk.Stop()
+			for range tk.C {
+				if _, err := storage.GC(context.Background(), config.Current().GCGrace, storage.DefaultGCLimit, false, nil); err != nil {
+					logger().Warnw("scheduled gc fail", "err", err)
+				}
+			}
+		}()
+	})
+}
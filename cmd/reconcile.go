@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-imsto/imsto/storage"
+)
+
+var cmdReconcile = &Command{
+	UsageLine: "reconcile -s ROOF [-repush] [-delete-orphans] [-mark-broken]",
+	Short:     "cross-check blobs in the engine and records of a roof",
+	Long: `
+list blobs of a roof in the storage engine and check them with the mapping records,
+report orphans (blobs without record), pending ones (uploads not set done), unknown keys
+and dangling records (records without blob), one JSON line for every finding and a summary at last,
+nothing is changed without a fix flag:
+  -repush          push dangling ones again from the cached original
+  -delete-orphans  delete orphan blobs
+  -mark-broken     mark dangling ones which can not be repushed as broken, they are not served
+`,
+}
+
+var (
+	rcRoof   = cmdReconcile.Flag.String("s", "", "roof")
+	rcRepush = cmdReconcile.Flag.Bool("repush", false, "push dangling ones again from cache")
+	rcDelete = cmdReconcile.Flag.Bool("delete-orphans", false, "delete orphan blobs")
+	rcMark   = cmdReconcile.Flag.Bool("mark-broken", false, "mark dangling ones as broken")
+	rcMinAge = cmdReconcile.Flag.Duration("min-age", time.Hour, "skip blobs newer than it, they may be in uploading")
+)
+
+func init() {
+	cmdReconcile.Run = runReconcile
+}
+
+func runReconcile(args []string) bool {
+	if *rcRoof == "" {
+		return false
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opt := storage.ReconcileOption{
+		Repush:        *rcRepush,
+		DeleteOrphans: *rcDelete,
+		MarkBroken:    *rcMark,
+		MinAge:        *rcMinAge,
+	}
+	enc := json.NewEncoder(os.Stdout)
+	rep, err := storage.Reconcile(ctx, *rcRoof, opt, func(f *storage.Finding) {
+		_ = enc.Encode(f)
+	})
+	if rep != nil {
+		_ = enc.Encode(rep)
+		if rep.Failed > 0 {
+			setExitStatus(1)
+		}
+	}
+	if err != nil {
+		logger().Warnw("reconcile fail", "roof", *rcRoof, "err", err)
+		setExitStatus(1)
+	}
+	return true
+}
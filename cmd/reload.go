@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage"
+)
+
+var reloadOnce sync.Once
+
+// watchReload 收到 SIGHUP 时重新载入配置，失败时保留当前配置，
+// meta_dsn 和监听地址等不会改变
+func watchReload() {
+	reloadOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGHUP)
+		go func() {
+			for range ch {
+				// tables of new roofs are created before the swap
+				if err := config.Reload(storage.CreateMetaTables); err != nil {
+					logger().Warnw("reload config fail, keep current", "err", err)
+					continue
+				}
+				logger().Infow("config reloaded", "file", config.File(), "roofs", config.Current().Roofs)
+			}
+		}()
+	})
+}
@@ -22,11 +22,12 @@ var (
 
 func init() {
 	cmdRPC.Run = runRPC
-	cmdRPC.Flag.StringVar(&rpcAddr, "addr", config.Current.RPCListen, "tcp listen address")
+	cmdRPC.Flag.StringVar(&rpcAddr, "addr", config.Current().RPCListen, "tcp listen address")
 	cmdRPC.Flag.BoolVar(&isTLS, "tls", false, " use tls")
 }
 
 func runRPC(args []string) bool {
+	watchReload()
 	fmt.Printf("Start RPC service %s at addr %s\n", config.Version, rpcAddr)
 	s := rpc.NewServer(rpcAddr, isTLS)
 	s.Serve()
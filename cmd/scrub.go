@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage"
+)
+
+var cmdScrub = &Command{
+	UsageLine: "scrub [-s ROOF] [-age 720h] [-rate 4194304] [-limit 0]",
+	Short:     "verify stored files with their content hashes",
+	Long: `
+read files of entries from the storage engine and compare them with the recorded hashes,
+entries verified in the age are skipped, the result and time are recorded for every entry,
+corrupted or missing ones are listed by GET /imsto/:roof/scrub,
+one JSON line is printed for every entry and a summary of every roof at last,
+all roofs are checked without -s
+`,
+}
+
+var (
+	scrubRoof  = cmdScrub.Flag.String("s", "", "roof, empty is all roofs")
+	scrubAge   = cmdScrub.Flag.Duration("age", 0, "check entries verified before it again, default is scrub_age of config")
+	scrubRate  = cmdScrub.Flag.Int64("rate", 0, "bytes read per second, 0 is no limit, default is scrub_rate of config")
+	scrubLimit = cmdScrub.Flag.Int("limit", 0, "max entries of a roof, 0 is no limit")
+)
+
+func init() {
+	cmdScrub.Run = runScrub
+}
+
+func scrubRoofs(roof string) []string {
+	if roof != "" {
+		return []string{roof}
+	}
+	var roofs []string
+	for r := range config.Current().Engines {
+		roofs = append(roofs, r)
+	}
+	return roofs
+}
+
+func runScrub(args []string) bool {
+	// 配置在参数定义之后才载入，这里再读
+	opt := storage.ScrubOption{Age: config.Current().ScrubAge, Rate: config.Current().ScrubRate, Limit: *scrubLimit}
+	if cmdScrub.IsSet("age") {
+		opt.Age = *scrubAge
+	}
+	if cmdScrub.IsSet("rate") {
+		opt.Rate = *scrubRate
+	}
+	if opt.Age < 0 || opt.Rate < 0 {
+		return false
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	enc := json.NewEncoder(os.Stdout)
+	var reps []*storage.ScrubReport
+	for _, roof := range scrubRoofs(*scrubRoof) {
+		rep, err := storage.Scrub(ctx, roof, opt, func(res *storage.ScrubResult) {
+			_ = enc.Encode(res)
+		})
+		if rep != nil {
+			reps = append(reps, rep)
+			if rep.Corrupted > 0 || rep.Missing > 0 || rep.Failed > 0 {
+				setExitStatus(1)
+			}
+		}
+		if err != nil {
+			logger().Warnw("scrub fail", "roof", roof, "err", err)
+			setExitStatus(1)
+			break
+		}
+	}
+	for _, rep := range reps {
+		_ = enc.Encode(rep)
+	}
+	return true
+}
+
+var scrubOnce sync.Once
+
+// scheduleScrub 按 scrub_interval 在后台校验所有 roof，为 0 时不执行
+func scheduleScrub() {
+	scrubOnce.Do(func() {
+		if config.Current().ScrubInterval <= 0 {
+			return
+		}
+		go func() {
+			tk := time.NewTicker(config.Current().ScrubInterval)
+			defer tk.Stop()
+			for range tk.C {
+				opt := storage.ScrubOption{
+					Age:   config.Current().ScrubAge,
+					Rate:  config.Current().ScrubRate,
+					Limit: storage.DefaultScrubLimit,
+				}
+				for _, roof := range scrubRoofs("") {
+					if _, err := storage.Scrub(context.Background(), roof, opt, nil); err != nil {
+						logger().Warnw("scheduled scrub fail", "roof", roof, "err", err)
+					}
+				}
+			}
+		}()
+	})
+}
@@ -23,10 +23,13 @@ var (
 
 func init() {
 	cmdStage.Run = runStage
-	cmdStage.Flag.StringVar(&saddr, "l", config.Current.StageListen, "tcp listen addr")
+	cmdStage.Flag.StringVar(&saddr, "l", config.Current().StageListen, "tcp listen addr")
 }
 
 func runStage(args []string) bool {
+	watchReload()
+	scheduleGC()
+	scheduleScrub()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", web.StageHandler)
@@ -37,7 +40,7 @@ func runStage(args []string) bool {
 	srv := &http.Server{
 		Addr:        saddr,
 		Handler:     mux,
-		ReadTimeout: config.Current.ReadTimeout,
+		ReadTimeout: config.Current().ReadTimeout,
 	}
 	err := srv.ListenAndServe()
 	if err != nil {
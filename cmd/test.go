@@ -71,7 +71,7 @@ func testApp(args []string) bool {
 			return false
 		}
 		defer file.Close()
-		entry, err := storage.NewEntryReader(file, path.Base(*tfile))
+		entry, err := storage.PrepareReader("demo", file, path.Base(*tfile))
 		if err != nil {
 			fmt.Println("new entry error: ", err)
 			return false
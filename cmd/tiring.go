@@ -23,10 +23,13 @@ var (
 
 func init() {
 	cmdTiring.Run = runTiring
-	cmdTiring.Flag.StringVar(&maddr, "l", config.Current.TiringListen, "tcp listen addr")
+	cmdTiring.Flag.StringVar(&maddr, "l", config.Current().TiringListen, "tcp listen addr")
 }
 
 func runTiring(args []string) bool {
+	watchReload()
+	scheduleGC()
+	scheduleScrub()
 
 	str := fmt.Sprintf("Start Tiring service %s at addr %s", config.Version, maddr)
 	fmt.Println(str)
@@ -34,7 +37,7 @@ func runTiring(args []string) bool {
 	srv := &http.Server{
 		Addr:        maddr,
 		Handler:     web.Handler(),
-		ReadTimeout: config.Current.ReadTimeout,
+		ReadTimeout: config.Current().ReadTimeout,
 	}
 	err := srv.ListenAndServe()
 	if err != nil {
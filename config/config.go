@@ -5,6 +5,9 @@ import (
 	"net"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -12,13 +15,51 @@ import (
 	"github.com/go-imsto/imsto/storage/imagio"
 )
 
-// Section ...
+// Section 每个 roof 的配置，未设置的项沿用全局配置
+// env: IMSTO_<ROOF>_MAX_FILESIZE, IMSTO_<ROOF>_SUPPORT_SIZE ...
 type Section struct { // example: {demo,file,Demo,/var/lib/imsto/,demo.imsto.org}
-	Name   string `json:"name,omitempty"`
-	Engine string `json:"engine,omitempty"`
-	Label  string `json:"label,omitempty"`
-	Root   string `json:"root,omitempty"`
-	Host   string `json:"host,omitempty"` // stage host
+	Name   string `json:"name,omitempty" yaml:"-" ignored:"true"`
+	Engine string `json:"engine,omitempty" yaml:"engine" ignored:"true"`
+	Label  string `json:"label,omitempty" yaml:"label" envconfig:"LABEL"`
+	Root   string `json:"root,omitempty" yaml:"local_root" envconfig:"LOCAL_ROOT"`
+	Host   string `json:"host,omitempty" yaml:"stage_host" envconfig:"STAGE_HOST"` // stage host
+
+	MaxFileSize  uint32 `json:"maxFileSize,omitempty" yaml:"max_filesize" envconfig:"MAX_FILESIZE"`
+	MaxWidth     uint32 `json:"maxWidth,omitempty" yaml:"max_width" envconfig:"MAX_WIDTH"`
+	MaxHeight    uint32 `json:"maxHeight,omitempty" yaml:"max_height" envconfig:"MAX_HEIGHT"`
+	MinWidth     uint32 `json:"minWidth,omitempty" yaml:"min_width" envconfig:"MIN_WIDTH"`
+	MinHeight    uint32 `json:"minHeight,omitempty" yaml:"min_height" envconfig:"MIN_HEIGHT"`
+	MaxQuality   uint8  `json:"maxQuality,omitempty" yaml:"max_quality" envconfig:"MAX_QUALITY"`
+	SupportSizes Sizes  `json:"sizes,omitempty" yaml:"support_size" envconfig:"SUPPORT_SIZE"`
+	Oversize     string `json:"oversize,omitempty" yaml:"oversize" envconfig:"OVERSIZE"` // reject|downscale
+	KeepRaw      bool   `json:"keepRaw,omitempty" yaml:"keep_raw" envconfig:"KEEP_RAW"`  // store untouched upload
+	Format       string `json:"format,omitempty" yaml:"format" envconfig:"FORMAT"`       // keep|webp|jpeg
+
+	MaxUpload uint32   `json:"maxUpload,omitempty" yaml:"max_upload" envconfig:"MAX_UPLOAD"` // size of upload, default is MaxFileSize
+	MaxPixels uint64   `json:"maxPixels,omitempty" yaml:"max_pixels" envconfig:"MAX_PIXELS"`
+	Formats   []string `json:"formats,omitempty" yaml:"formats" envconfig:"FORMATS"` // allowed: jpeg,png,gif,webp
+}
+
+// UploadLimit 上传 (读取) 的最大字节数
+func (sec Section) UploadLimit() int64 {
+	if sec.MaxUpload > 0 {
+		return int64(sec.MaxUpload)
+	}
+	return int64(sec.MaxFileSize)
+}
+
+// AllowFormat 是否允许的源格式，如 jpeg, png
+func (sec Section) AllowFormat(format string) bool {
+	if len(sec.Formats) == 0 {
+		return true
+	}
+	for _, f := range sec.Formats {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == format || f == "jpg" && format == "jpeg" {
+			return true
+		}
+	}
+	return false
 }
 
 // Sizes ...
@@ -37,30 +78,56 @@ func (z *IPNet) Decode(value string) error {
 	return nil
 }
 
+// UnmarshalText for config file
+func (z *IPNet) UnmarshalText(b []byte) error {
+	return z.Decode(string(b))
+}
+
 // Config ...
 type Config struct {
-	DatabaseDSN      string            `envconfig:"META_DSN"`
-	SentryDSN        string            `envconfig:"SENTRY_DSN"`
-	MaxFileSize      uint32            `envconfig:"MAX_FILESIZE" default:"2097152"` // 2MB
-	MaxWidth         uint32            `envconfig:"MAX_WIDTH" default:"1600"`
-	MaxHeight        uint32            `envconfig:"MAX_HEIGHT" default:"1600"`
-	MinWidth         uint32            `envconfig:"MIN_WIDTH" default:"50"`
-	MinHeight        uint32            `envconfig:"MIN_HEIGHT" default:"50"`
-	MaxQuality       uint8             `envconfig:"MAX_QUALITY" default:"88"`
-	CacheRoot        string            `envconfig:"CACHE_ROOT" default:"/opt/imsto/cache/"`
-	LocalRoot        string            `envconfig:"LOCAL_ROOT" default:"/var/lib/imsto/"`
-	StageHost        string            `envconfig:"STAGE_HOST"`     // stage.example.org
-	WatermarkFile    string            `envconfig:"WATERMARK_FILE"` // /opt/imsto/watermark.png
-	WatermarkOpacity uint8             `envconfig:"WATERMARK_OPACITY" default:"30"`
-	SupportSizes     Sizes             `envconfig:"SUPPORT_SIZE" default:"60,120,256"`
-	Roofs            []string          `envconfig:"ROOFS" default:"demo"` // roof1,roof2
-	Engines          map[string]string `envconfig:"ENGINES"`              // [roof]engine
-	Prefixes         map[string]string `envconfig:"PREFIXES"`             // [roof]prefix
-	WhiteList        []IPNet           `envconfig:"WHITELIST"`
-	ReadTimeout      time.Duration     `envconfig:"READ_TIMEOUT" default:"10s"`
-	TiringListen     string            `envconfig:"TIRING_LISTEN" default:":8967"`
-	StageListen      string            `envconfig:"STAGE_LISTEN" default:":8968"`
-	RPCListen        string            `envconfig:"RPC_LISTEN" default:":8969"`
+	DatabaseDSN      string             `envconfig:"META_DSN" yaml:"meta_dsn"`
+	SentryDSN        string             `envconfig:"SENTRY_DSN" yaml:"sentry_dsn"`
+	MaxFileSize      uint32             `envconfig:"MAX_FILESIZE" default:"2097152" yaml:"max_filesize"` // 2MB
+	MaxWidth         uint32             `envconfig:"MAX_WIDTH" default:"1600" yaml:"max_width"`
+	MaxHeight        uint32             `envconfig:"MAX_HEIGHT" default:"1600" yaml:"max_height"`
+	MinWidth         uint32             `envconfig:"MIN_WIDTH" default:"50" yaml:"min_width"`
+	MinHeight        uint32             `envconfig:"MIN_HEIGHT" default:"50" yaml:"min_height"`
+	MaxQuality       uint8              `envconfig:"MAX_QUALITY" default:"88" yaml:"max_quality"`
+	CacheRoot        string             `envconfig:"CACHE_ROOT" default:"/opt/imsto/cache/" yaml:"cache_root"`
+	LocalRoot        string             `envconfig:"LOCAL_ROOT" default:"/var/lib/imsto/" yaml:"local_root"`
+	StageHost        string             `envconfig:"STAGE_HOST" yaml:"stage_host"`         // stage.example.org
+	WatermarkFile    string             `envconfig:"WATERMARK_FILE" yaml:"watermark_file"` // /opt/imsto/watermark.png
+	WatermarkOpacity uint8              `envconfig:"WATERMARK_OPACITY" default:"30" yaml:"watermark_opacity"`
+	SupportSizes     Sizes              `envconfig:"SUPPORT_SIZE" default:"60,120,256" yaml:"support_size"`
+	Oversize         string             `envconfig:"OVERSIZE" default:"reject" yaml:"oversize"` // reject|downscale
+	KeepRaw          bool               `envconfig:"KEEP_RAW" yaml:"keep_raw"`                  // store untouched upload
+	Format           string             `envconfig:"FORMAT" default:"keep" yaml:"format"`       // keep|webp|jpeg
+	MaxUpload        uint32             `envconfig:"MAX_UPLOAD" yaml:"max_upload"`              // default is MaxFileSize
+	MaxPixels        uint64             `envconfig:"MAX_PIXELS" default:"50000000" yaml:"max_pixels"`
+	UploadExpire     time.Duration      `envconfig:"UPLOAD_EXPIRE" default:"24h" yaml:"upload_expire"`      // of unfinished resumable uploads
+	TrashRetention   time.Duration      `envconfig:"TRASH_RETENTION" default:"168h" yaml:"trash_retention"` // deleted entries can be restored in it
+	GCGrace          time.Duration      `envconfig:"GC_GRACE" default:"168h" yaml:"gc_grace"`               // keep files of deleted entries
+	GCInterval       time.Duration      `envconfig:"GC_INTERVAL" yaml:"gc_interval"`                        // scheduled gc in tiring or stage, 0 is off
+	ScrubInterval    time.Duration      `envconfig:"SCRUB_INTERVAL" yaml:"scrub_interval"`                  // background scrubber in tiring or stage, 0 is off
+	ScrubAge         time.Duration      `envconfig:"SCRUB_AGE" default:"720h" yaml:"scrub_age"`
+	ScrubRate        int64              `envconfig:"SCRUB_RATE" default:"4194304" yaml:"scrub_rate"` // bytes per second
+	Formats          []string           `envconfig:"FORMATS" default:"jpeg,png,gif,webp" yaml:"formats"`
+	Roofs            []string           `envconfig:"ROOFS" default:"demo" yaml:"roofs"` // roof1,roof2
+	Engines          map[string]string  `envconfig:"ENGINES" yaml:"engines"`            // [roof]engine
+	Prefixes         map[string]string  `envconfig:"PREFIXES" yaml:"prefixes"`          // [roof]prefix
+	NearDups         map[string]string  `envconfig:"NEAR_DUPS" yaml:"near_dups"`        // [roof]policy: reject|report
+	NearDupDistance  int                `envconfig:"NEAR_DUP_DISTANCE" default:"4" yaml:"near_dup_distance"`
+	FetchAllow       []string           `envconfig:"FETCH_ALLOW" yaml:"fetch_allow"` // hosts or CIDRs, empty allows all public addresses
+	FetchDeny        []string           `envconfig:"FETCH_DENY" yaml:"fetch_deny"`   // hosts or CIDRs
+	FetchRedirects   int                `envconfig:"FETCH_REDIRECTS" default:"3" yaml:"fetch_redirects"`
+	FetchTimeout     time.Duration      `envconfig:"FETCH_TIMEOUT" default:"25s" yaml:"fetch_timeout"`
+	FetchUserAgent   string             `envconfig:"FETCH_USER_AGENT" default:"imsto-fetcher/1.0" yaml:"fetch_user_agent"`
+	WhiteList        []IPNet            `envconfig:"WHITELIST" yaml:"whitelist"`
+	ReadTimeout      time.Duration      `envconfig:"READ_TIMEOUT" default:"10s" yaml:"read_timeout"`
+	TiringListen     string             `envconfig:"TIRING_LISTEN" default:":8967" yaml:"tiring_listen"`
+	StageListen      string             `envconfig:"STAGE_LISTEN" default:":8968" yaml:"stage_listen"`
+	RPCListen        string             `envconfig:"RPC_LISTEN" default:":8969" yaml:"rpc_listen"`
+	Sections         map[string]Section `ignored:"true" yaml:"sections"` // [roof]section, only in config file
 }
 
 // vars
@@ -68,50 +135,125 @@ var (
 	Version = "dev"
 	Name    = "imsto"
 
-	// Current ...
-	Current = new(Config)
+	current atomic.Pointer[Config]
+
+	sections   = make(map[string]*Section)
+	sectionsMu sync.RWMutex
 )
 
+// Current 当前的配置，重新载入时整体替换，不要修改它
+func Current() *Config {
+	return current.Load()
+}
+
 // InDevelop ...
 func InDevelop() bool {
 	return "dev" == Version
 }
 
 func init() {
-	if err := envconfig.Process(Name, Current); err != nil {
-		log.Printf("envconfig init ERR %s", err)
+	if err := Load(os.Getenv(strings.ToUpper(Name) + "_CONFIG")); err != nil {
+		log.Printf("config init ERR %s", err)
+		c := new(Config)
+		if err = envconfig.Process(Name, c); err != nil {
+			log.Printf("envconfig init ERR %s", err)
+		}
+		c.fillDefault()
+		current.Store(c)
 	}
+}
 
-	if len(Current.LocalRoot) < 2 {
+func (c *Config) fillDefault() {
+	if len(c.LocalRoot) < 2 {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
 			log.Printf("userHomeDir err %s", err)
 			return
 		}
-		Current.LocalRoot = path.Join(homeDir, Name)
+		c.LocalRoot = path.Join(homeDir, Name)
 	}
 
-	if len(Current.CacheRoot) < 2 {
+	if len(c.CacheRoot) < 2 {
 		cacheDir, err := os.UserCacheDir()
 		if err != nil {
 			log.Printf("userCacheDir err %s", err)
 			return
 		}
-		Current.CacheRoot = path.Join(cacheDir, Name)
+		c.CacheRoot = path.Join(cacheDir, Name)
+	}
+
+	// engine of section in config file
+	for roof, sec := range c.Sections {
+		if _, ok := c.Engines[roof]; !ok && sec.Engine != "" {
+			if c.Engines == nil {
+				c.Engines = make(map[string]string)
+			}
+			c.Engines[roof] = sec.Engine
+		}
 	}
 }
 
 // HasSection ...
 func HasSection(roof string) bool {
-	if _, ok := Current.Engines[roof]; ok {
+	if _, ok := Current().Engines[roof]; ok {
 		return true
 	}
 	return false
 }
 
+// GetSection 返回 roof 的配置，依次为全局值、配置文件中的 sections 和 IMSTO_<ROOF>_* 环境变量
+func GetSection(roof string) Section {
+	sectionsMu.RLock()
+	sec, ok := sections[roof]
+	sectionsMu.RUnlock()
+	if ok {
+		return *sec
+	}
+
+	sectionsMu.Lock()
+	defer sectionsMu.Unlock()
+	if sec, ok = sections[roof]; !ok {
+		sec = Current().section(roof)
+		sections[roof] = sec
+	}
+	return *sec
+}
+
+func (c *Config) section(roof string) *Section {
+	sec := &Section{
+		Name:         roof,
+		Engine:       c.Engines[roof],
+		Label:        roof,
+		Root:         c.LocalRoot,
+		Host:         c.StageHost,
+		MaxFileSize:  c.MaxFileSize,
+		MaxWidth:     c.MaxWidth,
+		MaxHeight:    c.MaxHeight,
+		MinWidth:     c.MinWidth,
+		MinHeight:    c.MinHeight,
+		MaxQuality:   c.MaxQuality,
+		SupportSizes: c.SupportSizes,
+		Oversize:     c.Oversize,
+		KeepRaw:      c.KeepRaw,
+		Format:       c.Format,
+		MaxUpload:    c.MaxUpload,
+		MaxPixels:    c.MaxPixels,
+		Formats:      c.Formats,
+	}
+	if fs, ok := c.Sections[roof]; ok {
+		overrideNonZero(sec, &fs)
+	}
+	if roof != "" {
+		if err := envconfig.Process(Name+"_"+roof, sec); err != nil {
+			log.Printf("envconfig section %s ERR %s", roof, err)
+		}
+	}
+	return sec
+}
+
 // GetEngine ...
 func GetEngine(roof string) string {
-	if v, ok := Current.Engines[roof]; ok {
+	if v, ok := Current().Engines[roof]; ok {
 		return v
 	}
 	return ""
@@ -119,12 +261,39 @@ func GetEngine(roof string) string {
 
 // GetPrefix ...
 func GetPrefix(roof string) string {
-	if s, ok := Current.Prefixes[roof]; ok && len(s) > 0 {
+	if s, ok := Current().Prefixes[roof]; ok && len(s) > 0 {
 		return s
 	}
 	return roof
 }
 
+// oversize policies
+const (
+	OversizeReject    = "reject"
+	OversizeDownscale = "downscale"
+)
+
+// format policies
+const (
+	FormatKeep = "keep" // keep source format
+	FormatWebP = "webp" // convert all to webp
+	FormatJPEG = "jpeg" // convert png without alpha to jpeg
+)
+
+// near duplicate policies
+const (
+	NearDupReject = "reject"
+	NearDupReport = "report"
+)
+
+// GetNearDup returns policy of near duplicate, empty means disabled
+func GetNearDup(roof string) string {
+	if s, ok := Current().NearDups[roof]; ok {
+		return s
+	}
+	return ""
+}
+
 // EnvOr ...
 func EnvOr(key, dft string) string {
 	if v, ok := os.LookupEnv(key); ok {
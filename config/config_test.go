@@ -21,3 +21,35 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, "file", GetEngine("demo"))
 	assert.Equal(t, "demos", GetPrefix("demo"))
 }
+
+func TestSection(t *testing.T) {
+	t.Setenv("IMSTO_AVATAR_MAX_WIDTH", "256")
+	t.Setenv("IMSTO_AVATAR_SUPPORT_SIZE", "48,96")
+	t.Setenv("IMSTO_AVATAR_STAGE_HOST", "avatar.example.org")
+
+	sec := GetSection("avatar")
+	assert.Equal(t, "avatar", sec.Name)
+	assert.Equal(t, uint32(256), sec.MaxWidth)
+	assert.Equal(t, Current().MaxHeight, sec.MaxHeight)
+	assert.Equal(t, Current().MaxFileSize, sec.MaxFileSize)
+	assert.Equal(t, Sizes{48, 96}, sec.SupportSizes)
+	assert.Equal(t, "avatar.example.org", sec.Host)
+
+	sec = GetSection("demo")
+	assert.Equal(t, "file", sec.Engine)
+	assert.Equal(t, Current().MaxWidth, sec.MaxWidth)
+	assert.Equal(t, Current().SupportSizes, sec.SupportSizes)
+}
+
+func TestAllowFormat(t *testing.T) {
+	sec := Section{Formats: []string{"jpg", "PNG"}}
+	assert.True(t, sec.AllowFormat("jpeg"))
+	assert.True(t, sec.AllowFormat("png"))
+	assert.False(t, sec.AllowFormat("gif"))
+	assert.True(t, Section{}.AllowFormat("webp"))
+}
+
+func TestUploadLimit(t *testing.T) {
+	assert.Equal(t, int64(1024), Section{MaxFileSize: 1024}.UploadLimit())
+	assert.Equal(t, int64(4096), Section{MaxFileSize: 1024, MaxUpload: 4096}.UploadLimit())
+}
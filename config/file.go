@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	loadMu     sync.Mutex
+	configFile string
+)
+
+// Load 读取配置文件 (YAML，可以为空)，再用环境变量覆盖，校验通过后整体替换 Current
+func Load(file string) error {
+	return load(file)
+}
+
+func load(file string, checks ...func(*Config) error) error {
+	c, err := parse(file)
+	if err != nil {
+		return err
+	}
+	if err = c.Validate(); err != nil {
+		if file == "" {
+			file = "env"
+		}
+		return fmt.Errorf("invalid config %s: %w", file, err)
+	}
+	for _, check := range checks {
+		if err = check(c); err != nil {
+			return err
+		}
+	}
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	configFile = file
+
+	// swap with cached sections together
+	sectionsMu.Lock()
+	current.Store(c)
+	sections = make(map[string]*Section)
+	sectionsMu.Unlock()
+	return nil
+}
+
+// Reload 重新载入上次的配置文件，新配置在 checks 都通过后才替换，失败时保留当前配置。
+// 数据库连接和监听地址等启动时使用的配置不会改变，需要重启
+func Reload(checks ...func(*Config) error) error {
+	return load(File(), checks...)
+}
+
+// File 返回当前使用的配置文件
+func File() string {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	return configFile
+}
+
+func parse(file string) (*Config, error) {
+	c := new(Config)
+	if err := envconfig.Process(Name, c); err != nil {
+		return nil, fmt.Errorf("envconfig: %w", err)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parse config %s: %w", file, err)
+		}
+
+		// 环境变量优先于配置文件
+		env := new(Config)
+		if err = envconfig.Process(Name, env); err != nil {
+			return nil, fmt.Errorf("envconfig: %w", err)
+		}
+		overrideEnv(c, env)
+	}
+	c.fillDefault()
+	return c, nil
+}
+
+// overrideEnv 把设置了环境变量的项从 env 复制到 c
+func overrideEnv(c, env *Config) {
+	cv := reflect.ValueOf(c).Elem()
+	ev := reflect.ValueOf(env).Elem()
+	t := cv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("envconfig")
+		if key == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(strings.ToUpper(Name + "_" + key)); ok {
+			cv.Field(i).Set(ev.Field(i))
+		}
+	}
+}
+
+// overrideNonZero 用 src 中非零值的项覆盖 dst
+func overrideNonZero(dst, src *Section) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		if !sv.Field(i).IsZero() {
+			dv.Field(i).Set(sv.Field(i))
+		}
+	}
+}
+
+// Validate 检查配置，返回所有的错误
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Roofs) == 0 {
+		errs = append(errs, errors.New("roofs: empty"))
+	}
+	if c.NearDupDistance < 0 || c.NearDupDistance > 7 {
+		errs = append(errs, fmt.Errorf("near_dup_distance: %d out of 0-7", c.NearDupDistance))
+	}
+	for roof, policy := range c.NearDups {
+		if policy != NearDupReject && policy != NearDupReport {
+			errs = append(errs, fmt.Errorf("near_dups.%s: unknown policy %q", roof, policy))
+		}
+	}
+	if c.UploadExpire <= 0 {
+		errs = append(errs, fmt.Errorf("upload_expire: %s must be positive", c.UploadExpire))
+	}
+	if c.GCGrace < 0 || c.GCInterval < 0 {
+		errs = append(errs, fmt.Errorf("gc_grace: %s, gc_interval: %s must not be negative", c.GCGrace, c.GCInterval))
+	}
+	if c.TrashRetention < 0 || c.TrashRetention > c.GCGrace {
+		errs = append(errs, fmt.Errorf("trash_retention: %s out of 0-%s (gc_grace)", c.TrashRetention, c.GCGrace))
+	}
+	if c.ScrubInterval < 0 || c.ScrubAge < 0 || c.ScrubRate < 0 {
+		errs = append(errs, fmt.Errorf("scrub_interval: %s, scrub_age: %s, scrub_rate: %d must not be negative",
+			c.ScrubInterval, c.ScrubAge, c.ScrubRate))
+	}
+	if c.FetchRedirects < 0 {
+		errs = append(errs, fmt.Errorf("fetch_redirects: %d is negative", c.FetchRedirects))
+	}
+	for _, s := range append(c.FetchAllow, c.FetchDeny...) {
+		if strings.Contains(s, "/") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(s)); err != nil {
+				errs = append(errs, fmt.Errorf("fetch_allow/fetch_deny: %s", err))
+			}
+		}
+	}
+	errs = append(errs, c.section("").validate("")...)
+	for roof := range c.Sections {
+		if _, ok := c.Engines[roof]; !ok {
+			errs = append(errs, fmt.Errorf("sections.%s: no engine", roof))
+		}
+		errs = append(errs, c.section(roof).validate("sections."+roof+".")...)
+	}
+	return errors.Join(errs...)
+}
+
+func (sec *Section) validate(prefix string) (errs []error) {
+	if sec.MaxFileSize == 0 {
+		errs = append(errs, fmt.Errorf("%smax_filesize: must be positive", prefix))
+	}
+	if sec.MaxWidth < sec.MinWidth {
+		errs = append(errs, fmt.Errorf("%smax_width: %d is less than min_width %d", prefix, sec.MaxWidth, sec.MinWidth))
+	}
+	if sec.MaxHeight < sec.MinHeight {
+		errs = append(errs, fmt.Errorf("%smax_height: %d is less than min_height %d", prefix, sec.MaxHeight, sec.MinHeight))
+	}
+	if sec.MaxQuality > 100 {
+		errs = append(errs, fmt.Errorf("%smax_quality: %d is greater than 100", prefix, sec.MaxQuality))
+	}
+	if sec.Oversize != OversizeReject && sec.Oversize != OversizeDownscale {
+		errs = append(errs, fmt.Errorf("%soversize: unknown policy %q", prefix, sec.Oversize))
+	}
+	switch sec.Format {
+	case FormatKeep, FormatWebP, FormatJPEG:
+	default:
+		errs = append(errs, fmt.Errorf("%sformat: unknown policy %q", prefix, sec.Format))
+	}
+	for _, f := range sec.Formats {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case "jpeg", "jpg", "png", "gif", "webp":
+		default:
+			errs = append(errs, fmt.Errorf("%sformats: unknown format %q", prefix, f))
+		}
+	}
+	for _, size := range sec.SupportSizes {
+		if size == 0 {
+			errs = append(errs, fmt.Errorf("%ssupport_size: zero size", prefix))
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testYAML = `
+max_width: 2048
+roofs: [demo, avatar]
+near_dups:
+  demo: report
+sections:
+  demo:
+    engine: file
+  avatar:
+    engine: file
+    max_width: 512
+    max_height: 512
+    support_size: [48, 96]
+`
+
+func TestLoad(t *testing.T) {
+	t.Cleanup(func() { _ = Load("") })
+	dir := t.TempDir()
+	file := path.Join(dir, "imsto.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testYAML), 0644))
+
+	t.Setenv("IMSTO_MAX_HEIGHT", "1024")
+	assert.NoError(t, Load(file))
+	assert.Equal(t, file, File())
+	assert.Equal(t, uint32(2048), Current().MaxWidth)
+	assert.Equal(t, uint32(1024), Current().MaxHeight) // env over file
+	assert.Equal(t, []string{"demo", "avatar"}, Current().Roofs)
+	assert.Equal(t, "file", GetEngine("avatar"))
+	assert.Equal(t, "file", GetEngine("demo"))
+	assert.Equal(t, NearDupReport, GetNearDup("demo"))
+
+	sec := GetSection("avatar")
+	assert.Equal(t, uint32(512), sec.MaxWidth)
+	assert.Equal(t, Sizes{48, 96}, sec.SupportSizes)
+	assert.Equal(t, Current().MaxFileSize, sec.MaxFileSize)
+
+	// invalid config keeps current
+	bad := path.Join(dir, "bad.yaml")
+	assert.NoError(t, os.WriteFile(bad, []byte("min_width: 4096\nnear_dups: {demo: drop}\nfetch_deny: [10.0.0.0/33]\ngc_grace: -1h\nscrub_rate: -1\nnear_dup_distance: 8\n"), 0644))
+	err := Load(bad)
+	assert.ErrorContains(t, err, "max_width")
+	assert.ErrorContains(t, err, "near_dups.demo")
+	assert.ErrorContains(t, err, "fetch_deny")
+	assert.ErrorContains(t, err, "gc_grace")
+	assert.ErrorContains(t, err, "trash_retention")
+	assert.ErrorContains(t, err, "scrub_rate")
+	assert.ErrorContains(t, err, "near_dup_distance")
+	assert.Equal(t, file, File())
+	assert.Equal(t, uint32(2048), Current().MaxWidth)
+
+	assert.ErrorContains(t, Load(path.Join(dir, "typo.yaml")), "read config")
+	assert.NoError(t, os.WriteFile(bad, []byte("max_widht: 100\n"), 0644))
+	assert.ErrorContains(t, Load(bad), "max_widht")
+
+	assert.NoError(t, os.WriteFile(file, []byte("max_width: 800\n"), 0644))
+	assert.NoError(t, Reload())
+	assert.Equal(t, uint32(800), Current().MaxWidth)
+	assert.Equal(t, uint32(800), GetSection("demo").MaxWidth)
+}
@@ -38,11 +38,13 @@ CREATE TABLE map_template (
 	path entry_path NOT NULL ,
 	size int NOT NULL DEFAULT 0 CHECK (size >= 0),
 	sev jsonb NOT NULL DEFAULT '{}'::jsonb, -- storage info
-	status smallint NOT NULL DEFAULT 0, -- 0=valid,1=deleted
-	roofs varCHAR(12)[] NOT NULL DEFAULT '{}',
+	status smallint NOT NULL DEFAULT 0, -- 0=valid,1=deleted by all roofs, waiting for gc,2=broken (file is lost)
+	roofs varCHAR(12)[] NOT NULL DEFAULT '{}', -- roofs refer to it
+	home varCHAR(12) NOT NULL DEFAULT '', -- roof of the stored file, empty is roofs[1]
 	created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	PRIMARY KEY (id)
 ) WITHOUT OIDS;
+CREATE INDEX idx_map_status ON map_template (status) ;
 
 -- meta browsable
 CREATE TABLE meta_template (
@@ -61,6 +63,7 @@ CREATE TABLE meta_template (
 	status smallint NOT NULL DEFAULT 0, -- 0=valid,1=hidden
 	created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	tags varCHAR(40)[] NOT NULL DEFAULT '{}',
+	extra jsonb NOT NULL DEFAULT '{}'::jsonb, -- blurhash, lqip etc.
 	UNIQUE (hashes),
 	PRIMARY KEY (id)
 ) WITHOUT OIDS;
@@ -68,11 +71,20 @@ CREATE INDEX idx_meta_created ON meta_template (status, created) ;
 CREATE INDEX idx_meta_size ON meta_template (size) ;
 CREATE INDEX idx_meta_tags ON meta_template (tags) ;
 
+-- bands of 8 bits of a perceptual hash (16 hex digits), hashes within distance 7 share a band
+CREATE OR REPLACE FUNCTION phash_bands(a_phash text) RETURNS text[] AS $$
+	SELECT array_agg(i || ':' || substr(a_phash, i * 2 - 1, 2)) FROM generate_series(1, 8) i
+$$ LANGUAGE sql IMMUTABLE STRICT;
+CREATE INDEX idx_meta_phash ON meta_template USING gin (phash_bands(extra->>'phash')) ;
+
+-- trash, an entry of every roof is kept until gc
 CREATE TABLE meta__deleted
 (
-	LIKE meta_template INCLUDING ALL,
-	deleted timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+	LIKE meta_template INCLUDING DEFAULTS,
+	deleted timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id, roof)
 ) WITHOUT OIDS;
+CREATE INDEX idx_meta_deleted ON meta__deleted (roof, deleted) ;
 
 -- entry presave
 CREATE TABLE meta__prepared (
@@ -80,12 +92,40 @@ CREATE TABLE meta__prepared (
 ) WITHOUT OIDS;
 
 
+-- tags of every roof, item_count is kept by procedures
 CREATE TABLE tag(
 	id serial ,
-	label varchar(80) NOT NULL UNIQUE,
+	roof varCHAR(12) NOT NULL DEFAULT '',
+	label varchar(80) NOT NULL,
 	item_count int NOT NULL DEFAULT 0,
+	UNIQUE (roof, label),
 	PRIMARY KEY  (id)
 );
 
+-- remote source of fetched entries
+CREATE TABLE source (
+	roof varCHAR(12) NOT NULL,
+	uri text NOT NULL,
+	item_id entry_xid NOT NULL,
+	referer text NOT NULL DEFAULT '',
+	etag text NOT NULL DEFAULT '',
+	last_modified text NOT NULL DEFAULT '',
+	created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	checked timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (roof, uri)
+) WITHOUT OIDS;
+CREATE INDEX idx_source_item ON source (roof, item_id) ;
+
+-- last integrity check of entries
+CREATE TABLE scrub (
+	roof varCHAR(12) NOT NULL,
+	item_id entry_xid NOT NULL,
+	status smallint NOT NULL DEFAULT 0, -- 0=ok,1=corrupted,2=missing
+	error text NOT NULL DEFAULT '',
+	checked timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (roof, item_id)
+) WITHOUT OIDS;
+CREATE INDEX idx_scrub_status ON scrub (roof, status) ;
+
 
 END;
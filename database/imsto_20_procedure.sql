@@ -131,7 +131,7 @@ BEGIN
 		-- TODO: merge roofs
 		IF NOT t_roofs @> i_roofs THEN
 		t_roofs := t_roofs || i_roofs;
-		EXECUTE 'UPDATE ' || tbname || ' SET roofs = $1 WHERE id = $2'
+		EXECUTE 'UPDATE ' || tbname || ' SET roofs = $1, status = 0 WHERE id = $2'
 		USING t_roofs, a_id;
 		END IF;
 		RETURN -1;
@@ -154,7 +154,7 @@ LANGUAGE 'plpgsql' VOLATILE;
 CREATE OR REPLACE FUNCTION entry_save (a_roof text,
 	a_id text, a_path text, a_name text, a_size int, a_meta jsonb, a_sev jsonb
 	, a_hashes jsonb, a_ids text[]
-	, a_appid int, a_author int, a_tags text[])
+	, a_appid int, a_author int, a_tags text[], a_extra jsonb)
 
 RETURNS int AS
 $$
@@ -199,11 +199,13 @@ BEGIN
 	END IF;
 
 	-- save entry meta
-	EXECUTE 'INSERT INTO ' || tb_meta || '(id, path, name, size, meta, hashes, ids, sev, app_id, author, roof, tags)
+	EXECUTE 'INSERT INTO ' || tb_meta || '(id, path, name, size, meta, hashes, ids, sev, app_id, author, roof, tags, extra)
 	 VALUES (
-		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 	)'
-	USING a_id, a_path, a_name, a_size, a_meta, a_hashes, a_ids, a_sev, a_appid, a_author, a_roof, a_tags;
+	USING a_id, a_path, a_name, a_size, a_meta, a_hashes, a_ids, a_sev, a_appid, a_author, a_roof, a_tags, a_extra;
+
+	PERFORM tag_count(a_roof, a_tags, 1);
 
 RETURN 1;
 END;
@@ -253,7 +255,7 @@ IF NOT FOUND THEN
 END IF;
 
 SELECT entry_save(m_rec.roof, m_rec.id, m_rec.path, m_rec.name, m_rec.size, m_rec.meta, a_sev,
- m_rec.hashes, m_rec.ids, m_rec.app_id, m_rec.author, m_rec.tags) INTO t_ret;
+ m_rec.hashes, m_rec.ids, m_rec.app_id, m_rec.author, m_rec.tags, m_rec.extra) INTO t_ret;
 
 DELETE FROM meta__prepared WHERE id = a_id;
 
@@ -264,21 +266,59 @@ $$
 LANGUAGE 'plpgsql' VOLATILE;
 
 
-CREATE OR REPLACE FUNCTION entry_delete(a_roof text, a_id text)
+-- 从映射中去掉一个 roof，没有 roof 引用时标记为删除等待 gc，返回剩余的引用数
+CREATE OR REPLACE FUNCTION map_unlink(a_id text, a_roof text)
 RETURNS int AS
 $$
 DECLARE
-	tb_hash text;
 	tb_map text;
+	t_roofs text[];
+	t_home text;
+BEGIN
+
+	tb_map := 'mapping_' || substr(a_id, 1, 2);
+	EXECUTE 'SELECT roofs, home FROM '||tb_map||' WHERE id = $1 LIMIT 1'
+	INTO t_roofs, t_home
+	USING a_id;
+
+	IF t_roofs IS NULL THEN
+		RETURN -1;
+	END IF;
+
+	-- the file stays in the engine of the first roof
+	IF t_home = '' THEN
+		t_home := COALESCE(t_roofs[1], '');
+	END IF;
+	t_roofs := array_remove(t_roofs, a_roof);
+
+	IF COALESCE(array_length(t_roofs, 1), 0) = 0 THEN
+		-- keep it for gc of the stored file
+		RAISE NOTICE 'deleted mapping: %.%', tb_map, a_id;
+		EXECUTE 'UPDATE '||tb_map||' SET roofs = $1, home = $2, status = 1 WHERE id = $3'
+		USING '{}'::text[], t_home, a_id;
+		RETURN 0;
+	END IF;
+
+	EXECUTE 'UPDATE '||tb_map||' SET roofs = $1, home = $2 WHERE id = $3'
+	USING t_roofs, t_home, a_id;
+
+	RETURN array_length(t_roofs, 1);
+END;
+$$
+LANGUAGE 'plpgsql' VOLATILE;
+
+
+-- 删除 roof 中的条目，其他 roof 仍引用时只解除关联并返回 2
+CREATE OR REPLACE FUNCTION entry_delete(a_roof text, a_id text)
+RETURNS int AS
+$$
+DECLARE
 	tb_meta text;
 	rec RECORD;
 	s text;
+	t_left int;
 BEGIN
 
-	tb_map := 'mapping_' || substr(a_id, 1, 2);
-	RAISE NOTICE 'delete mapping: %.%', tb_map, a_id;
-	EXECUTE 'DELETE FROM '||tb_map||' WHERE id = $1' USING a_id;
-
 	tb_meta := 'meta_' || a_roof;
 	EXECUTE 'SELECT * FROM '||tb_meta||' WHERE id = $1 LIMIT 1'
 	INTO rec
@@ -288,31 +328,102 @@ BEGIN
 		RETURN -1;
 	END IF;
 
-	IF NOT EXISTS (SELECT status FROM meta__deleted WHERE id = a_id) THEN
-		INSERT INTO meta__deleted (id, path, name, roof, meta, hashes, ids, size
-			, sev, exif, app_id, author, status, created, tags)
-		 VALUES(rec.id, rec.path, rec.name, rec.roof, rec.meta, rec.hashes, rec.ids, rec.size
-		 , rec.sev, COALESCE(rec.exif, '{}'), rec.app_id, rec.author, rec.status, rec.created, rec.tags);
+	-- move to trash of the roof
+	DELETE FROM meta__deleted WHERE id = a_id AND roof = a_roof;
+	INSERT INTO meta__deleted (id, path, name, roof, meta, hashes, ids, size
+		, sev, exif, app_id, author, status, created, tags, extra)
+	 VALUES(rec.id, rec.path, rec.name, a_roof, rec.meta, rec.hashes, rec.ids, rec.size
+	 , rec.sev, COALESCE(rec.exif, '{}'), rec.app_id, rec.author, rec.status, rec.created, rec.tags
+	 , COALESCE(rec.extra, '{}'));
+
+	EXECUTE 'DELETE FROM '||tb_meta||' WHERE id = $1'
+	USING a_id;
+	PERFORM tag_count(a_roof, rec.tags, -1);
+
+	-- unlink mapping
+	t_left := map_unlink(a_id, a_roof);
+	FOR s IN SELECT UNNEST(rec.ids) AS value LOOP
+		IF s <> a_id THEN
+			PERFORM map_unlink(s, a_roof);
+		END IF;
+	END LOOP;
+
+	IF t_left > 0 THEN
+		RAISE NOTICE 'content % is still used by % roofs', a_id, t_left;
+		RETURN 2;
 	END IF;
 
-	-- delete hashes
+	-- delete hashes of the last reference
 	EXECUTE 'DELETE FROM hash_' || substr(rec.hashes->>'hash', 1, 1)||' WHERE hashed = $1' USING rec.hashes->>'hash';
 	IF rec.hashes ? 'hash2' AND rec.hashes ? 'size2' THEN
 		EXECUTE 'DELETE FROM hash_' || substr(rec.hashes->>'hash2', 1, 1)||' WHERE hashed = $1' USING rec.hashes->>'hash2';
 	END IF;
 
-	-- delete mapping
+	RETURN 1;
+
+END;
+$$
+LANGUAGE 'plpgsql' VOLATILE;
+
+
+-- 从回收站恢复 roof 中的条目，-1: 不在回收站，-2: 文件已清除，-3: roof 中已有同样的条目
+CREATE OR REPLACE FUNCTION entry_restore(a_roof text, a_id text)
+RETURNS int AS
+$$
+DECLARE
+	tb_meta text;
+	rec RECORD;
+	s text;
+	t_status smallint;
+BEGIN
+
+	SELECT * FROM meta__deleted WHERE id = a_id AND roof = a_roof INTO rec;
+	IF NOT FOUND THEN
+		RETURN -1;
+	END IF;
+
+	EXECUTE 'SELECT status FROM mapping_'||substr(a_id, 1, 2)||' WHERE id = $1 LIMIT 1'
+	INTO t_status
+	USING a_id;
+	IF t_status IS NULL THEN
+		RETURN -2;
+	END IF;
+
+	tb_meta := 'meta_' || a_roof;
+	IF EXISTS (SELECT 1 FROM pg_catalog.pg_tables WHERE schemaname = 'imsto' AND tablename = tb_meta) THEN
+		t_status := NULL;
+		EXECUTE 'SELECT status FROM '||tb_meta||' WHERE id = $1 OR hashes = $2 LIMIT 1'
+		INTO t_status
+		USING a_id, rec.hashes;
+		IF t_status IS NOT NULL THEN
+			RETURN -3;
+		END IF;
+	END IF;
+
+	-- hashes are deleted with the last reference
+	PERFORM hash_save(rec.hashes->>'hash', a_id, rec.path, (rec.hashes->>'size')::int);
+	IF rec.hashes ? 'hash2' AND rec.hashes ? 'size2' THEN
+		PERFORM hash_save(rec.hashes->>'hash2', a_id, rec.path, (rec.hashes->>'size2')::int);
+	END IF;
+
+	-- link mapping again, map_save reactivates it
 	FOR s IN SELECT UNNEST(rec.ids) AS value LOOP
-		tb_map := 'mapping_' || substr(s, 1, 2);
-		RAISE NOTICE 'delete mapping: %.%', tb_map, s;
-		EXECUTE 'DELETE FROM '||tb_map||' WHERE id = $1' USING s;
+		PERFORM map_save(s, rec.path, rec.name, rec.size, rec.sev, a_roof);
 	END LOOP;
+	IF rec.ids IS NULL OR NOT rec.ids @> ARRAY[a_id::varchar] THEN
+		PERFORM map_save(a_id, rec.path, rec.name, rec.size, rec.sev, a_roof);
+	END IF;
 
-	EXECUTE 'DELETE FROM '||tb_meta||' WHERE id = $1'
-	USING a_id;
+	EXECUTE 'INSERT INTO ' || tb_meta || '(id, path, name, roof, meta, hashes, ids, size
+		, sev, exif, app_id, author, status, created, tags, extra)
+	 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)'
+	USING rec.id, rec.path, rec.name, a_roof, rec.meta, rec.hashes, rec.ids, rec.size
+	 , rec.sev, rec.exif, rec.app_id, rec.author, rec.status, rec.created, rec.tags, rec.extra;
+	PERFORM tag_count(a_roof, rec.tags, 1);
 
-	RETURN 1;
+	DELETE FROM meta__deleted WHERE id = a_id AND roof = a_roof;
 
+	RETURN 1;
 END;
 $$
 LANGUAGE 'plpgsql' VOLATILE;
@@ -325,6 +436,7 @@ DECLARE
 	tb_meta text;
 	rec RECORD;
 	n_tags text[];
+	c_tags text[];
 	s text;
 	t_ret int;
 
@@ -346,10 +458,12 @@ BEGIN
 	END IF;
 
 	t_ret := 0;
+	c_tags := ARRAY[]::text[];
 
 	FOR s IN SELECT UNNEST(a_tags) LOOP
 		IF NOT n_tags @> ARRAY[s] THEN
 			n_tags := n_tags || s;
+			c_tags := c_tags || s;
 			t_ret := t_ret + 1;
 		END IF;
 
@@ -359,6 +473,7 @@ BEGIN
 	IF t_ret > 0 THEN
 		EXECUTE 'UPDATE '||tb_meta||' SET tags = $1 WHERE id = $2'
 		USING n_tags, a_id;
+		PERFORM tag_count(a_roof, c_tags, 1);
 	END IF;
 
 	RETURN t_ret;
@@ -376,6 +491,7 @@ DECLARE
 	tb_meta text;
 	rec RECORD;
 	n_tags text[];
+	c_tags text[];
 	s text;
 	t_ret int;
 
@@ -398,13 +514,15 @@ BEGIN
 	t_ret := 0;
 
 	n_tags := ARRAY[]::text[];
+	c_tags := ARRAY[]::text[];
 
 	FOR s IN SELECT UNNEST(rec.tags) LOOP
 		-- RAISE NOTICE 'CHECK tag: % %', s, a_tags;
 		IF NOT a_tags @> ARRAY[s] THEN
-			RAISE NOTICE 'will remove tag: %', s;
 			n_tags := n_tags || s;
 		ELSE
+			RAISE NOTICE 'will remove tag: %', s;
+			c_tags := c_tags || s;
 			t_ret := t_ret + 1;
 		END IF;
 
@@ -415,6 +533,7 @@ BEGIN
 	IF t_ret > 0 THEN
 		EXECUTE 'UPDATE '||tb_meta||' SET tags = $1 WHERE id = $2'
 		USING n_tags, a_id;
+		PERFORM tag_count(a_roof, c_tags, -1);
 	END IF;
 
 	RETURN t_ret;
@@ -425,6 +544,81 @@ $$
 LANGUAGE 'plpgsql' VOLATILE;
 
 
+-- 调整 roof 中标签的条目数
+CREATE OR REPLACE FUNCTION tag_count(a_roof text, a_tags text[], a_delta int)
+RETURNS int AS
+$$
+BEGIN
+	IF a_tags IS NULL OR array_length(a_tags, 1) IS NULL THEN
+		RETURN 0;
+	END IF;
+
+	INSERT INTO tag (roof, label, item_count)
+	SELECT a_roof, t, GREATEST(a_delta, 0) FROM (SELECT DISTINCT UNNEST(a_tags) AS t) s
+	ON CONFLICT (roof, label) DO UPDATE SET item_count = GREATEST(tag.item_count + a_delta, 0);
+
+	RETURN array_length(a_tags, 1);
+END;
+$$
+LANGUAGE 'plpgsql' VOLATILE;
+
+
+-- 按 roof 中的条目重新统计标签
+CREATE OR REPLACE FUNCTION tag_recount(a_roof text)
+RETURNS int AS
+$$
+DECLARE
+	t_ret int;
+BEGIN
+	DELETE FROM tag WHERE roof = a_roof;
+
+	EXECUTE 'INSERT INTO tag (roof, label, item_count)
+	SELECT $1, t, count(*) FROM (SELECT UNNEST(tags) AS t FROM meta_' || a_roof || ') s GROUP BY t'
+	USING a_roof;
+	GET DIAGNOSTICS t_ret = ROW_COUNT;
+
+	RETURN t_ret;
+END;
+$$
+LANGUAGE 'plpgsql' VOLATILE;
+
+
+-- roof 中所有条目的标签 a_from 改为 a_to，已有 a_to 的合并，返回改变的条目数
+CREATE OR REPLACE FUNCTION tag_rename(a_roof text, a_from text, a_to text)
+RETURNS int AS
+$$
+DECLARE
+	tb_meta text;
+	t_merged int;
+	t_renamed int;
+BEGIN
+	IF a_from = a_to THEN
+		RETURN 0;
+	END IF;
+
+	tb_meta := 'meta_' || a_roof;
+
+	EXECUTE 'UPDATE '||tb_meta||' SET tags = array_remove(tags, $1::varchar)
+	 WHERE tags @> ARRAY[$1, $2]::varchar[]'
+	USING a_from, a_to;
+	GET DIAGNOSTICS t_merged = ROW_COUNT;
+
+	EXECUTE 'UPDATE '||tb_meta||' SET tags = array_replace(tags, $1::varchar, $2::varchar)
+	 WHERE tags @> ARRAY[$1]::varchar[]'
+	USING a_from, a_to;
+	GET DIAGNOSTICS t_renamed = ROW_COUNT;
+
+	IF t_renamed > 0 THEN
+		PERFORM tag_count(a_roof, ARRAY[a_to], t_renamed);
+	END IF;
+	DELETE FROM tag WHERE roof = a_roof AND label = a_from;
+
+	RETURN t_merged + t_renamed;
+END;
+$$
+LANGUAGE 'plpgsql' VOLATILE;
+
+
 
 
 END;
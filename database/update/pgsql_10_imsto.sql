@@ -80,3 +80,74 @@ ALTER DOMAIN entry_id RENAME TO entry_xid;
 ALTER TABLE meta_template ADD UNIQUE (hashes);
 ALTER TABLE meta__prepared ADD UNIQUE (hashes);
 ALTER TABLE meta_demo ADD UNIQUE (hashes);
+
+-- 20261019 placeholder (blurhash, lqip)
+BEGIN;
+ALTER TABLE meta_template ADD extra jsonb NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE meta__prepared ADD extra jsonb NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE meta__deleted ADD extra jsonb NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE meta_demo ADD extra jsonb NOT NULL DEFAULT '{}'::jsonb;
+DROP FUNCTION IF EXISTS entry_save(text, text, text, text, int, jsonb, jsonb, jsonb, text[], int, int, text[]);
+END;
+-- then reload imsto_20_procedure.sql
+
+-- 20261019 source of fetched entries
+CREATE TABLE source (
+	roof varCHAR(12) NOT NULL,
+	uri text NOT NULL,
+	item_id entry_xid NOT NULL,
+	referer text NOT NULL DEFAULT '',
+	etag text NOT NULL DEFAULT '',
+	last_modified text NOT NULL DEFAULT '',
+	created timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	checked timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (roof, uri)
+) WITHOUT OIDS;
+CREATE INDEX idx_source_item ON source (roof, item_id) ;
+
+-- 20261019 cross roof links, the mapping_* tables inherit the column
+ALTER TABLE map_template ADD home varCHAR(12) NOT NULL DEFAULT '';
+-- then reload imsto_20_procedure.sql
+
+-- 20261019 gc of deleted files, mapping rows are kept with status 1 until gc
+CREATE INDEX idx_map_status ON map_template (status) ;
+-- then reload imsto_20_procedure.sql
+
+-- 20261019 integrity scrubbing
+CREATE TABLE scrub (
+	roof varCHAR(12) NOT NULL,
+	item_id entry_xid NOT NULL,
+	status smallint NOT NULL DEFAULT 0, -- 0=ok,1=corrupted,2=missing
+	error text NOT NULL DEFAULT '',
+	checked timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (roof, item_id)
+) WITHOUT OIDS;
+CREATE INDEX idx_scrub_status ON scrub (roof, status) ;
+
+-- 20261019 trash of every roof
+ALTER TABLE meta__deleted DROP CONSTRAINT IF EXISTS meta__deleted_pkey;
+ALTER TABLE meta__deleted DROP CONSTRAINT IF EXISTS meta__deleted_hashes_key;
+ALTER TABLE meta__deleted ADD PRIMARY KEY (id, roof);
+CREATE INDEX idx_meta_deleted ON meta__deleted (roof, deleted) ;
+-- then reload imsto_20_procedure.sql
+
+-- 20261019 tags of every roof
+ALTER TABLE tag ADD roof varCHAR(12) NOT NULL DEFAULT '';
+ALTER TABLE tag DROP CONSTRAINT IF EXISTS tag_label_key;
+ALTER TABLE tag ADD UNIQUE (roof, label);
+-- then reload imsto_20_procedure.sql, and count tags of every roof
+SELECT tag_recount('demo');
+
+-- 20261019 index of perceptual hashes for similar search
+CREATE OR REPLACE FUNCTION phash_bands(a_phash text) RETURNS text[] AS $$
+	SELECT array_agg(i || ':' || substr(a_phash, i * 2 - 1, 2)) FROM generate_series(1, 8) i
+$$ LANGUAGE sql IMMUTABLE STRICT;
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOR t IN SELECT tablename FROM pg_tables WHERE schemaname = 'imsto'
+	 AND tablename LIKE 'meta\_%' AND tablename NOT LIKE 'meta\_\_%' LOOP
+		EXECUTE 'CREATE INDEX IF NOT EXISTS idx_' || t || '_phash ON ' || t || ' USING gin (phash_bands(extra->>''phash''))';
+	END LOOP;
+END $$;
@@ -3,6 +3,12 @@ package rpc
 import (
 	"bytes"
 	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/go-imsto/imsto-client/impb"
 	"github.com/go-imsto/imsto/config"
@@ -13,6 +19,19 @@ var (
 	_ pb.ImageSvcServer = (*rpcImage)(nil)
 )
 
+// header keys for the extra of entry, pb.ImageOutput has no fields for them
+const (
+	mdBlurHash = "x-imsto-blurhash"
+	mdLQIP     = "x-imsto-lqip"
+)
+
+// request header keys of Store with an empty image, pb.ImageInput has no fields for them
+const (
+	mdHash  = "x-imsto-hash"  // content hash as hash.SumContent
+	mdClaim = "x-imsto-claim" // "true" to link the entry to the caller
+	mdTags  = "x-imsto-tags"  // tags of claim
+)
+
 type rpcImage struct {
 	pb.UnimplementedImageSvcServer
 }
@@ -38,7 +57,7 @@ func (ri *rpcImage) Fetch(ctx context.Context, in *pb.FetchInput) (*pb.ImageOutp
 		return nil, err
 	}
 
-	return ri.loadImageOutput(entry, in.SizeOp)
+	return ri.loadImageOutput(ctx, in.Roof, entry, in.SizeOp)
 }
 
 func (ri *rpcImage) Store(ctx context.Context, in *pb.ImageInput) (*pb.ImageOutput, error) {
@@ -48,7 +67,13 @@ func (ri *rpcImage) Store(ctx context.Context, in *pb.ImageInput) (*pb.ImageOutp
 		return nil, err
 	}
 
-	entry, err := storage.NewEntryReader(bytes.NewReader(in.Image), in.Name)
+	if len(in.Image) == 0 {
+		if h := incomingValue(ctx, mdHash); h != "" {
+			return ri.storeByHash(ctx, in, app, h)
+		}
+	}
+
+	entry, err := storage.PrepareReader(in.Roof, bytes.NewReader(in.Image), in.Name)
 	if err != nil {
 		reportError(err, nil)
 		return nil, err
@@ -62,10 +87,42 @@ func (ri *rpcImage) Store(ctx context.Context, in *pb.ImageInput) (*pb.ImageOutp
 		return nil, err
 	}
 
-	return ri.loadImageOutput(entry, in.SizeOp)
+	return ri.loadImageOutput(ctx, in.Roof, entry, in.SizeOp)
 }
 
-func (ri *rpcImage) loadImageOutput(entry *storage.Entry, sizeOp string) (*pb.ImageOutput, error) {
+// storeByHash 按内容哈希查找已有的条目，不存在时返回 NotFound
+func (ri *rpcImage) storeByHash(ctx context.Context, in *pb.ImageInput, app *storage.App, h string) (*pb.ImageOutput, error) {
+	var (
+		entry *storage.Entry
+		err   error
+	)
+	if incomingValue(ctx, mdClaim) == "true" {
+		entry, err = storage.Claim(in.Roof, h, app.Id, storage.Author(in.UserID), incomingValue(ctx, mdTags))
+	} else {
+		entry, err = storage.LookupHash(in.Roof, h)
+	}
+	switch {
+	case errors.Is(err, storage.ErrInvalidHash):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrHashNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		reportError(err, nil)
+		return nil, err
+	}
+	return ri.loadImageOutput(ctx, in.Roof, entry, in.SizeOp)
+}
+
+func incomingValue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(key); len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+func (ri *rpcImage) loadImageOutput(ctx context.Context, roof string, entry *storage.Entry, sizeOp string) (*pb.ImageOutput, error) {
 
 	spath := "orig/" + entry.Path
 	if sizeOp != "" {
@@ -77,10 +134,12 @@ func (ri *rpcImage) loadImageOutput(entry *storage.Entry, sizeOp string) (*pb.Im
 		}
 	}
 
+	setExtraHeader(ctx, entry)
+
 	return &pb.ImageOutput{
 		Path: entry.Path,
 		Uri:  "/" + storage.CatView + "/" + spath,
-		Host: config.Current.StageHost,
+		Host: config.GetSection(roof).Host,
 		ID:   entry.Id.String(),
 		Meta: &pb.ImageMeta{
 			Width:   int32(entry.Meta.Width),
@@ -92,3 +151,20 @@ func (ri *rpcImage) loadImageOutput(entry *storage.Entry, sizeOp string) (*pb.Im
 		},
 	}, nil
 }
+
+// setExtraHeader send placeholders of entry with response header
+func setExtraHeader(ctx context.Context, entry *storage.Entry) {
+	var kv []string
+	if s := entry.ExtraString(storage.ExtraBlurHash); s != "" {
+		kv = append(kv, mdBlurHash, s)
+	}
+	if s := entry.ExtraString(storage.ExtraLQIP); s != "" {
+		kv = append(kv, mdLQIP, s)
+	}
+	if len(kv) == 0 {
+		return
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(kv...)); err != nil {
+		logger().Infow("set header fail", "id", entry.Id, "err", err)
+	}
+}
@@ -2,11 +2,13 @@ package backend
 
 import (
 	"fmt"
-	"github.com/go-imsto/imsto/config"
-	"github.com/go-imsto/imsto/storage/types"
+	"io"
 	"path"
 	"strings"
 	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/types"
 )
 
 // Meta ...
@@ -63,6 +65,11 @@ type Wagoner interface {
 	Delete(k Key) error
 }
 
+// Opener 可以流式读取的引擎，key 不存在时返回 fs.ErrNotExist
+type Opener interface {
+	Open(k Key) (io.ReadCloser, error)
+}
+
 var engines = make(map[string]engine)
 
 // RegisterEngine Register a Engine
@@ -95,3 +102,12 @@ func ID2Path(r string) string {
 	}
 	return r[0:2] + "/" + r[2:4] + "/" + r[4:]
 }
+
+// Path2ID 还原 ID2Path 拆分的路径
+func Path2ID(p string) string {
+	a := strings.SplitN(p, "/", 3)
+	if len(a) != 3 || len(a[0]) != 2 || len(a[1]) != 2 || strings.Contains(a[2], "/") {
+		return p
+	}
+	return a[0] + a[1] + a[2]
+}
@@ -0,0 +1,15 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath2ID(t *testing.T) {
+	for _, id := range []string{"abcdef01.jpg", "ab.jpg", "abcdefgh"} {
+		assert.Equal(t, id, Path2ID(ID2Path(id)))
+	}
+	assert.Equal(t, "ab/c/def.jpg", Path2ID("ab/c/def.jpg"))
+	assert.Equal(t, "ab/cd/ef/g.jpg", Path2ID("ab/cd/ef/g.jpg"))
+}
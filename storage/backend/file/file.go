@@ -3,9 +3,12 @@ package backend
 import (
 	"encoding/json"
 	"errors"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/go-imsto/imsto/config"
@@ -44,7 +47,7 @@ func init() {
 }
 
 func locDial(roof string) (Wagoner, error) {
-	dir := checkLocalDir(config.Current.LocalRoot)
+	dir := checkLocalDir(config.Current().LocalRoot)
 	if dir == "" {
 		return nil, errors.New("config local_root is empty")
 	}
@@ -64,14 +67,51 @@ func (l *locWagon) filterError(err error) error {
 func (l *locWagon) Exists(k Key) (exist bool, err error) {
 	_, err = os.Stat(path.Join(l.root, k.Path()))
 	if os.IsNotExist(err) {
-		exist = false
+		return false, nil
 	}
-	exist = true
-	return
+	return err == nil, err
 }
 
+// List 与 S3 一样按 key 排序，返回 Marker 之后以 Prefix 开头的文件，不含 .meta
 func (l *locWagon) List(ls ListSpec) (items []ListItem, err error) {
-	// TODO:
+	// walk from the directory of prefix only
+	root := filepath.Clean(l.root)
+	dir := root
+	if i := strings.LastIndex(ls.Prefix, "/"); i > 0 {
+		dir = filepath.Join(root, ls.Prefix[:i])
+	}
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && name == dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		key := filepath.ToSlash(strings.TrimPrefix(name, root+string(filepath.Separator)))
+		if d.IsDir() {
+			// a directory can not contain keys after the marker
+			if name != dir && ls.Marker != "" && key+"/" < ls.Marker && !strings.HasPrefix(ls.Marker, key+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(key, ".meta") || !strings.HasPrefix(key, ls.Prefix) || key <= ls.Marker {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mt := fi.ModTime()
+		items = append(items, ListItem{Key: key, Size: uint32(fi.Size()), LastModified: &mt})
+		if ls.Limit > 0 && len(items) >= ls.Limit {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		err = l.filterError(err)
+	}
 	return
 }
 
@@ -85,6 +125,11 @@ func (l *locWagon) Get(k Key) (data []byte, err error) {
 	return
 }
 
+// Open ...
+func (l *locWagon) Open(k Key) (io.ReadCloser, error) {
+	return os.Open(path.Join(l.root, k.Path()))
+}
+
 func (l *locWagon) Put(k Key, data []byte, meta Meta) (sev Meta, err error) {
 	name := path.Join(l.root, k.Path())
 	dir := path.Dir(name)
@@ -115,6 +160,9 @@ func (l *locWagon) Put(k Key, data []byte, meta Meta) (sev Meta, err error) {
 
 func (l *locWagon) Delete(k Key) error {
 	name := path.Join(l.root, k.Path())
+	if err := os.Remove(name + ".meta"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Remove(name)
 }
 
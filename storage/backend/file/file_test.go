@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	l := &locWagon{root: t.TempDir() + "/"}
+	for _, id := range []string{"abcdef01.jpg", "abcdef02.jpg", "abce0001.png", "bcde0001.jpg"} {
+		_, err := l.Put(Key{ID: id, Cat: "demo"}, []byte("x"), Meta{})
+		assert.NoError(t, err)
+	}
+	_, err := l.Put(Key{ID: "abcdef01.png", Cat: "demo/raw"}, []byte("xy"), Meta{})
+	assert.NoError(t, err)
+	_, err = l.Put(Key{ID: "abcdef01.jpg", Cat: "other"}, []byte("x"), Meta{})
+	assert.NoError(t, err)
+
+	items, err := l.List(ListSpec{Prefix: "demo/", Limit: 3})
+	assert.NoError(t, err)
+	if assert.Len(t, items, 3) {
+		assert.Equal(t, "demo/ab/cd/ef01.jpg", items[0].Key)
+		assert.Equal(t, "demo/ab/cd/ef02.jpg", items[1].Key)
+		assert.Equal(t, "demo/ab/ce/0001.png", items[2].Key)
+		assert.Equal(t, uint32(1), items[0].Size)
+		assert.NotNil(t, items[0].LastModified)
+	}
+	items, err = l.List(ListSpec{Prefix: "demo/", Marker: items[2].Key})
+	assert.NoError(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, "demo/bc/de/0001.jpg", items[0].Key)
+		assert.Equal(t, "demo/raw/ab/cd/ef01.png", items[1].Key)
+	}
+	items, err = l.List(ListSpec{Prefix: "none/"})
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	k := Key{ID: "abcdef01.jpg", Cat: "demo"}
+	ok, err := l.Exists(k)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, l.Delete(k))
+	ok, err = l.Exists(k)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
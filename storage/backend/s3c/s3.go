@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -161,6 +163,34 @@ func (c *s3Conn) Get(k Key) (data []byte, err error) {
 	return
 }
 
+// Open 流式读取，调用者需要关闭
+func (c *s3Conn) Open(k Key) (rc io.ReadCloser, err error) {
+	var req *http.Request
+	req, err = http.NewRequest("GET", c.getURL(k.Path()), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("x-amz-content-sha256", emptySum)
+	var resp *http.Response
+	resp, err = c.ac.Do(req)
+	if err != nil {
+		logger().Infow("open fail", "key", k, "err", err)
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		err = fmt.Errorf("%w: %s", fs.ErrNotExist, k.Path())
+	default:
+		logger().Infow("open status", "code", resp.StatusCode)
+		err = ErrRequest
+	}
+	resp.Body.Close()
+	return
+}
+
 func metaToMaps(h Meta) (m map[string][]string) {
 	m = make(map[string][]string)
 	for k, v := range h {
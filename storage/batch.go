@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-imsto/imid"
+)
+
+// ops of batch
+const (
+	OpDelete    = "delete"
+	OpTag       = "tag"
+	OpUntag     = "untag"
+	OpSetAuthor = "set-author"
+)
+
+// MaxBatchOps 一次批量操作最多的条目数
+const MaxBatchOps = 1000
+
+// errors of batch
+var (
+	ErrInvalidOp     = errors.New("invalid op")
+	ErrTooManyOps    = errors.New("too many ops")
+	ErrEntryNotFound = errors.New("entry not found")
+	ErrEmptyTags     = errors.New("empty tags")
+	ErrRolledBack    = errors.New("batch rolled back")
+)
+
+// BatchOp 批量中的一个操作
+type BatchOp struct {
+	Op     string      `json:"op" form:"op"`
+	ID     string      `json:"id" form:"id"`
+	Tags   StringArray `json:"tags,omitempty" form:"tags"`     // tag, untag
+	Author Author      `json:"author,omitempty" form:"author"` // set-author
+}
+
+// BatchResult 一个操作的结果，Changed 为删除或改变的数量
+type BatchResult struct {
+	Op      string `json:"op"`
+	ID      string `json:"id"`
+	OK      bool   `json:"ok"`
+	Changed int    `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// check 校验并规范 id 和标签
+func (op *BatchOp) check() error {
+	eid, err := imid.ParseID(op.ID)
+	if err != nil {
+		return err
+	}
+	op.ID = eid.String()
+	switch op.Op {
+	case OpDelete, OpSetAuthor:
+	case OpTag, OpUntag:
+		if op.Tags = cleanTags(op.Tags); len(op.Tags) == 0 {
+			return ErrEmptyTags
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidOp, op.Op)
+	}
+	return nil
+}
+
+// Batch 在一个事务中执行，每个操作有一个保存点，失败的只回滚它自己，
+// atomic 时有失败就全部回滚并返回 ErrRolledBack，不是 app 的条目返回 ErrNotOwner
+func (mw *MetaWrap) Batch(app AppID, ops []BatchOp, atomic bool) (res []*BatchResult, err error) {
+	if len(ops) > MaxBatchOps {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooManyOps, len(ops), MaxBatchOps)
+	}
+	res = make([]*BatchResult, len(ops))
+	var failed int
+	err = mw.withTxQuery(func(tx *sql.Tx) error {
+		for i := range ops {
+			op := &ops[i]
+			r := &BatchResult{Op: op.Op, ID: op.ID}
+			res[i] = r
+			if err := op.check(); err != nil {
+				r.Error = err.Error()
+				failed++
+				continue
+			}
+			r.ID = op.ID
+			if _, err := tx.Exec("SAVEPOINT batch_op"); err != nil {
+				return err
+			}
+			n, err := mw.batchExec(tx, app, op)
+			if err != nil {
+				r.Error = err.Error()
+				failed++
+				if _, err = tx.Exec("ROLLBACK TO SAVEPOINT batch_op"); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err = tx.Exec("RELEASE SAVEPOINT batch_op"); err != nil {
+				return err
+			}
+			r.OK, r.Changed = true, n
+		}
+		if atomic && failed > 0 {
+			return fmt.Errorf("%w: %d of %d failed", ErrRolledBack, failed, len(ops))
+		}
+		return nil
+	})
+	if err != nil {
+		// nothing is committed
+		for _, r := range res {
+			if r != nil {
+				r.OK, r.Changed = false, 0
+			}
+		}
+	}
+	for _, r := range res {
+		if r != nil && r.OK && r.Op == OpDelete {
+			purgeCached(r.ID)
+		}
+	}
+	logger().Infow("batch done", "roof", mw.roof, "ops", len(ops), "failed", failed, "err", err)
+	return
+}
+
+// batchExec 执行一个操作，返回改变的数量
+func (mw *MetaWrap) batchExec(tx *sql.Tx, app AppID, op *BatchOp) (n int, err error) {
+	var owner AppID
+	err = tx.QueryRow("SELECT app_id FROM "+mw.table()+" WHERE id = $1 FOR UPDATE", op.ID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrEntryNotFound, op.ID)
+	}
+	if err != nil {
+		return
+	}
+	if owner != app {
+		return 0, fmt.Errorf("%w: %s", ErrNotOwner, op.ID)
+	}
+	switch op.Op {
+	case OpDelete:
+		err = tx.QueryRow("SELECT entry_delete($1, $2)", mw.tableSuffix, op.ID).Scan(&n)
+		if err == nil && n < 0 {
+			return 0, fmt.Errorf("%w: %s", ErrEntryNotFound, op.ID)
+		}
+		n = 1
+	case OpTag, OpUntag:
+		fn := "tag_map"
+		if op.Op == OpUntag {
+			fn = "tag_unmap"
+		}
+		err = tx.QueryRow("SELECT "+fn+"($1, $2, VARIADIC $3::text[])", mw.tableSuffix, op.ID, op.Tags).Scan(&n)
+		switch {
+		case err != nil:
+		case n == -2:
+			return 0, fmt.Errorf("%w: %s", ErrEntryNotFound, op.ID)
+		case n < 0:
+			n = 0 // nothing to change
+		}
+	case OpSetAuthor:
+		var r sql.Result
+		if r, err = tx.Exec("UPDATE "+mw.table()+" SET author = $1 WHERE id = $2 AND app_id = $3", op.Author, op.ID, app); err != nil {
+			return
+		}
+		if a, _ := r.RowsAffected(); a == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrEntryNotFound, op.ID)
+		}
+		n = 1
+	}
+	return
+}
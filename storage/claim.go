@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-imsto/imsto/storage/hash"
+)
+
+// lookupHash 按内容哈希 (hash.SumContent) 查找，哈希表不分 roof
+func lookupHash(mw MetaWrapper, h string) (*HashEntry, *mapItem, error) {
+	if !hash.Valid(h) {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidHash, h)
+	}
+	h = strings.ToLower(h)
+	eh, err := mw.GetHash(h)
+	if err == nil {
+		var item *mapItem
+		if item, err = mw.GetMapping(eh.ID.String()); err == nil {
+			return eh, item, nil
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: %s", ErrHashNotFound, h)
+	}
+	return nil, nil, err
+}
+
+// LookupHash 查找 roof 中已有的内容，其他 roof 中的只能通过 Claim 关联
+func LookupHash(roof, h string) (*Entry, error) {
+	mw := NewMetaWrapper(roof)
+	_, item, err := lookupHash(mw, h)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := mw.GetMeta(item.ID.String())
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: %s", ErrHashNotFound, h)
+	}
+	return entry, err
+}
+
+// Claim 不上传内容，把已有的内容关联到 roof 和调用者，tags 为逗号分隔
+func Claim(roof, h string, app AppID, author Author, tags string) (*Entry, error) {
+	mw := NewMetaWrapper(roof)
+	eh, _, err := lookupHash(mw, h)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{AppId: app, Author: author, Tags: StringArray{}}
+	if err = e.linkExist(roof, mw, eh); err != nil {
+		return nil, err
+	}
+	if tags = strings.Trim(strings.TrimSpace(tags), ","); tags != "" {
+		if err = mw.MapTags(e.Id.String(), tags); err != nil {
+			return nil, err
+		}
+	}
+	entry, err := mw.GetMeta(e.Id.String())
+	if err != nil {
+		return nil, err
+	}
+	logger().Infow("claimed", "roof", roof, "hash", h, "id", entry.Id, "app", app, "author", author)
+	return entry, nil
+}
@@ -3,10 +3,14 @@ package storage
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"path"
+	"slices"
+	"strings"
 	"time"
 
 	iimg "github.com/go-imsto/imagi"
@@ -14,6 +18,7 @@ import (
 	"github.com/go-imsto/imsto/config"
 	"github.com/go-imsto/imsto/storage/backend"
 	"github.com/go-imsto/imsto/storage/hash"
+	"github.com/go-imsto/imsto/storage/imagio"
 	cdb "github.com/go-imsto/imsto/storage/types"
 	"github.com/go-imsto/imsto/utils"
 )
@@ -32,19 +37,37 @@ type mapItem struct {
 	Name    string      `json:"name"`
 	Size    uint32      `json:"size"`
 	Path    string      `json:"path"`
-	Roofs   StringArray `json:"roofs,omitempty"`
+	Roofs   StringArray `json:"roofs,omitempty"` // roofs refer to it
+	Home    string      `json:"home,omitempty"`  // roof of the stored file, empty is Roofs[0]
 	Status  uint8       `json:"-"`
 	Created *time.Time  `json:"created,omitempty"`
 	sev     cdb.Meta
 }
 
+// roof 文件所在的 roof
 func (e *mapItem) roof() string {
+	if e.Home != "" {
+		return e.Home
+	}
 	if len(e.Roofs) > 0 {
 		return e.Roofs[0]
 	}
 	return ""
 }
 
+// ownedBy app 是否拥有某个 roof 中的条目
+func (e *mapItem) ownedBy(app AppID) bool {
+	for _, r := range e.Roofs {
+		if config.GetEngine(r) == "" {
+			continue
+		}
+		if entry, err := NewMetaWrapper(r).GetMeta(e.ID.String()); err == nil && entry.AppId == app {
+			return true
+		}
+	}
+	return false
+}
+
 type Entry struct {
 	Id      imid.IID    `json:"id"`
 	Name    string      `json:"name"`
@@ -56,26 +79,44 @@ type Entry struct {
 	Roofs   StringArray `json:"roofs,omitempty"`
 	Tags    StringArray `json:"tags,omitempty"`
 	Meta    *iimg.Attr  `json:"meta,omitempty"`
+	Extra   cdb.Meta    `json:"extra,omitempty"`
 	AppId   AppID       `json:"appid,omitempty"`
 	Author  Author      `json:"author,omitempty"`
 	Created time.Time   `json:"created,omitempty"`
 
-	Key string `json:"key,omitempty"`   // for upload response
-	Err string `json:"error,omitempty"` // for upload response
+	Key  string      `json:"key,omitempty"`   // for upload response
+	Err  string      `json:"error,omitempty"` // for upload response
+	Near []*NearItem `json:"near,omitempty"`  // for upload response, near duplicates
 
 	exif cdb.Meta
 	sev  cdb.Meta
 
-	b  []byte
-	h  string
-	im *iimg.Image
+	b   []byte
+	h   string
+	im  *iimg.Image
+	src image.Image // decoded source
+
+	animated bool // gif with frames
+	resized  bool // src is downscaled, encode it instead of im
+
+	raw    []byte // untouched upload
+	rawExt string
 
 	_treked bool
 	ret     int // db saved result
 }
 
+// NearItem 近似重复的已有条目
+type NearItem struct {
+	ID       imid.IID `json:"id"`
+	Path     string   `json:"path"`
+	Distance int      `json:"distance"`
+}
+
 const (
 	minSize = 43
+
+	nearDupRows = 5
 )
 
 func (e *Entry) GetHash() string {
@@ -85,11 +126,13 @@ func (e *Entry) GetHash() string {
 // NewEntryReader ...
 func NewEntryReader(rs io.ReadSeeker, name string) (e *Entry, err error) {
 	w := hash.New()
-	io.Copy(w, rs)
 	e = &Entry{
 		Name:    name,
 		Created: time.Now(),
 	}
+	if _, err = io.Copy(w, rs); err != nil {
+		return
+	}
 	rs.Seek(0, 0)
 	e.im, err = iimg.Open(rs)
 	if err != nil {
@@ -98,9 +141,11 @@ func NewEntryReader(rs io.ReadSeeker, name string) (e *Entry, err error) {
 	}
 	e.Size = w.Len()
 	e.Meta = e.im.Attr
+	e.rawExt = e.im.Ext
 
 	e.h = w.String()
 	e.Tags = StringArray{}
+	e.decodeSource(rs)
 	return
 }
 
@@ -111,29 +156,47 @@ func (e *Entry) Trek(roof string) (err error) {
 	}
 	e._treked = true
 
+	sec := config.GetSection(roof)
+	width, height := e.im.Width, e.im.Height
+	format := e.targetFormat(sec)
+	if sec.Oversize == config.OversizeDownscale {
+		if err = e.downscale(sec.MaxWidth, sec.MaxHeight, format); err != nil {
+			return
+		}
+	}
+
 	var wopt *iimg.WriteOption
 	wopt, err = filterImageAttr(roof, e.im.Attr)
 	if err != nil {
 		return
 	}
 
-	var buf bytes.Buffer
-	_, err = e.im.SaveTo(&buf, wopt)
-	if err != nil {
-		logger().Infow("im.SaveTo fail", "id", e.Id, "err", err)
+	wopt.Format = format
+	if err = e.saveTo(wopt); err != nil {
 		return
 	}
-	logger().Infow("im.SaveTo OK", "id", e.Id, "size", buf.Len(), "name", e.Name)
-
-	e.b = buf.Bytes()
+	if sec.Oversize == config.OversizeDownscale {
+		if err = e.fitFileSize(sec, wopt); err != nil {
+			return
+		}
+	}
+	if wopt.Format != "" || e.resized {
+		if err = e.reopen(); err != nil {
+			return
+		}
+	}
 
 	size := len(e.b)
-	if uint32(size) > config.Current.MaxFileSize {
-		err = fmt.Errorf("file: %s size %d is too big, max is %d", e.Name, size, config.Current.MaxFileSize)
+	if uint32(size) > sec.MaxFileSize {
+		err = fmt.Errorf("%w: file %s size %d, max is %d", ErrTooLarge, e.Name, size, sec.MaxFileSize)
 		return
 	}
 
 	hashes := cdb.Meta{"hash": e.h, "size": e.Size}
+	if e.im.Width != width || e.im.Height != height {
+		hashes["width"], hashes["height"] = width, height
+		hashes["width2"], hashes["height2"] = e.im.Width, e.im.Height
+	}
 	ids := cdb.StringArray{e.Id.String()}
 	hash2 := hash.SumContent(e.b)
 	if hash2 != e.h {
@@ -146,12 +209,121 @@ func (e *Entry) Trek(roof string) (err error) {
 		e.Meta = e.im.Attr
 		e.Path = e.Id.String() + e.im.Attr.Ext
 	}
+	if sec.KeepRaw && len(e.raw) > 0 {
+		hashes["raw"] = e.Id.String() + e.rawExt
+	}
 	e.Hashes = hashes
 	e.IDs = ids
 
+	e.trekExtra()
+
 	return
 }
 
+func (e *Entry) saveTo(wopt *iimg.WriteOption) error {
+	var buf bytes.Buffer
+	if e.resized {
+		// 缩小后的只在这里编码一次
+		ext := e.im.Ext
+		if wopt.Format != "" {
+			ext = wopt.Format
+		}
+		if err := imagio.Encode(&buf, e.src, ext, uint8(wopt.Quality)); err != nil {
+			logger().Infow("encode resized fail", "id", e.Id, "err", err)
+			return err
+		}
+	} else if _, err := e.im.SaveTo(&buf, wopt); err != nil {
+		logger().Infow("im.SaveTo fail", "id", e.Id, "err", err)
+		return err
+	}
+	logger().Infow("im.SaveTo OK", "id", e.Id, "size", buf.Len(), "name", e.Name, "quality", wopt.Quality)
+	e.b = buf.Bytes()
+	return nil
+}
+
+// targetFormat 按 roof 的策略返回要转换的格式，空为保持原格式
+func (e *Entry) targetFormat(sec config.Section) string {
+	ext := strings.TrimPrefix(e.im.Ext, ".")
+	switch sec.Format {
+	case config.FormatWebP:
+		if ext != "webp" {
+			return "webp"
+		}
+	case config.FormatJPEG:
+		if ext == "png" && e.src != nil && imagio.IsOpaque(e.src) {
+			return "jpg"
+		}
+	}
+	return ""
+}
+
+// reopen 转换格式或缩小后重新读取图片信息
+func (e *Entry) reopen() error {
+	im, err := iimg.Open(bytes.NewReader(e.b))
+	if err != nil {
+		logger().Infow("reopen fail", "name", e.Name, "err", err)
+		return err
+	}
+	logger().Infow("converted", "name", e.Name, "from", e.im.Ext, "to", im.Ext)
+	e.im = im
+	return nil
+}
+
+// downscale 等比缩小到 maxWidth x maxHeight 以内，只缩小源图和尺寸，由 saveTo 按 format (空为原格式) 编码
+func (e *Entry) downscale(maxWidth, maxHeight uint32, format string) error {
+	if e.im.Width <= maxWidth && e.im.Height <= maxHeight {
+		return nil
+	}
+	ext := e.im.Ext
+	if format != "" {
+		ext = format
+	}
+	// 只有第一帧，动画不缩小
+	if e.src == nil || e.animated || !imagio.CanEncode(ext) {
+		return fmt.Errorf("%w: dimension %dx%d of %s can not downscale to %s", ErrTooLarge, e.im.Width, e.im.Height, e.im.Ext, ext)
+	}
+	m := imagio.Fit(e.src, uint(maxWidth), uint(maxHeight))
+	b := m.Bounds()
+	attr := *e.im.Attr
+	attr.Width, attr.Height = uint32(b.Dx()), uint32(b.Dy())
+	logger().Infow("downscaled", "name", e.Name, "from", fmt.Sprintf("%dx%d", e.im.Width, e.im.Height),
+		"to", fmt.Sprintf("%dx%d", attr.Width, attr.Height))
+	e.im.Attr = &attr
+	e.src, e.resized = m, true
+	return nil
+}
+
+const (
+	fitQualityStep = 8
+	fitQualityMin  = 60
+	fitMaxTries    = 12
+)
+
+// fitFileSize 逐步降低质量直到满足文件大小的限制，仍然超出时再缩小尺寸
+func (e *Entry) fitFileSize(sec config.Section, wopt *iimg.WriteOption) error {
+	lossy := e.im.Ext != ".png" && e.im.Ext != ".gif"
+	if wopt.Format != "" {
+		lossy = true // jpg or webp
+	}
+	for i := 0; uint32(len(e.b)) > sec.MaxFileSize && i < fitMaxTries; i++ {
+		if lossy && wopt.Quality >= fitQualityMin+fitQualityStep {
+			wopt.Quality -= fitQualityStep
+		} else {
+			w, h := e.im.Width*3/4, e.im.Height*3/4
+			if w < sec.MinWidth || h < sec.MinHeight {
+				break
+			}
+			if err := e.downscale(w, h, wopt.Format); err != nil {
+				return err
+			}
+		}
+		if err := e.saveTo(wopt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Store ...
 func (e *Entry) Store(roof string) (ch chan error) {
 	ch = make(chan error, 1)
@@ -166,35 +338,17 @@ func (e *Entry) Store(roof string) (ch chan error) {
 	}
 	mw := NewMetaWrapper(roof)
 	if eh, err := mw.GetHash(e.h); err == nil {
-		logger().Infow("exist hash", "eh", eh)
-
-		e.Id = eh.ID
-		e.Path = eh.Path
-		_ne, _err := mw.GetMapping(eh.ID.String())
-		if _err != nil {
-			logger().Warnw("exist mapping is invalid", "ne", _ne, "err", _err)
-			ch <- _err
-			return
-		}
-
-		e.Name = _ne.Name
-		// e.Path = _ne.Path
-		e.Size = _ne.Size
-		e.Created = *_ne.Created
-		e.Roofs = _ne.Roofs
-		e.sev = _ne.sev
-		e.reset()
-		e._treked = true
-
-		if err = mw.Save(e, true); err != nil {
-			logger().Warnw("mw.Save fail", "entry", e, "err", err)
+		if err = e.linkExist(roof, mw, eh); err != nil {
 			ch <- err
 			return
 		}
-		logger().Infow("exist entry", "id", e.Id, "path", e.Path)
 		close(ch)
 		return
 	}
+	if err := e.checkNearDup(roof, mw); err != nil {
+		ch <- err
+		return
+	}
 
 	if e.Id == 0 || e.Path == "" {
 		id, err := mw.NextID() // generate new ID
@@ -206,14 +360,25 @@ func (e *Entry) Store(roof string) (ch chan error) {
 		e.Path = e.Id.String() + e.im.Attr.Ext
 	}
 
+	h := e.h
 	if err := e.Trek(roof); err != nil {
 		ch <- err
 		return
 	}
 	logger().Infow("trek ok", "entry", e)
+	if e.h != h { // dedup with normalized bytes
+		if eh, err := mw.GetHash(e.h); err == nil {
+			if err = e.linkExist(roof, mw, eh); err != nil {
+				ch <- err
+				return
+			}
+			close(ch)
+			return
+		}
+	}
 	// log.Printf("new id: %v, size: %d, path: %v\n", e.Id, e.Size, e.Path)
 
-	thumbRoot := path.Join(config.Current.CacheRoot, "thumb")
+	thumbRoot := path.Join(config.Current().CacheRoot, "thumb")
 	filename := path.Join(thumbRoot, "orig", storedPath(e.Path))
 
 	if err := utils.SaveFile(filename, e.b); err != nil {
@@ -241,6 +406,100 @@ func (e *Entry) Store(roof string) (ch chan error) {
 	return
 }
 
+// linkExist 使用已存在的条目，内容在其他 roof 时在本 roof 新建条目并关联
+func (e *Entry) linkExist(roof string, mw MetaWrapper, eh *HashEntry) error {
+	logger().Infow("exist hash", "eh", eh)
+
+	e.Id = eh.ID
+	e.Path = eh.Path
+	_ne, err := mw.GetMapping(eh.ID.String())
+	if err != nil {
+		logger().Warnw("exist mapping is invalid", "ne", _ne, "err", err)
+		return err
+	}
+
+	e.Name = _ne.Name
+	// e.Path = _ne.Path
+	e.Size = _ne.Size
+	e.Created = *_ne.Created
+	e.Roofs = _ne.Roofs
+	e.sev = _ne.sev
+	_me, own, err := findMeta(roof, _ne)
+	if err != nil {
+		// the mapping is left without entry, nothing to link
+		logger().Warnw("entry of exist hash not found", "roof", roof, "id", eh.ID, "roofs", _ne.Roofs)
+		return fmt.Errorf("%w: %s has no entry", ErrHashNotFound, eh.ID)
+	}
+	e.Extra = _me.Extra
+	e.reset()
+	e._treked = true
+
+	if own {
+		err = mw.Save(e, true)
+	} else {
+		e.Meta = _me.Meta
+		e.Hashes = _me.Hashes
+		e.IDs = _me.IDs
+		if !slices.Contains(e.Roofs, roof) {
+			e.Roofs = append(e.Roofs, roof)
+		}
+		err = mw.Save(e, false)
+	}
+	if err != nil {
+		logger().Warnw("mw.Save fail", "entry", e, "err", err)
+		return err
+	}
+	logger().Infow("exist entry", "roof", roof, "id", e.Id, "path", e.Path, "linked", !own)
+	return nil
+}
+
+// findMeta 找到内容的条目，先找 roof 自己，再找引用它的其他 roof
+func findMeta(roof string, item *mapItem) (entry *Entry, own bool, err error) {
+	id := item.ID.String()
+	if entry, err = NewMetaWrapper(roof).GetMeta(id); err == nil {
+		return entry, true, nil
+	}
+	for _, r := range item.Roofs {
+		if r == roof || config.GetEngine(r) == "" {
+			continue
+		}
+		if entry, err = NewMetaWrapper(r).GetMeta(id); err == nil {
+			return entry, false, nil
+		}
+	}
+	return nil, false, sql.ErrNoRows
+}
+
+// checkNearDup 按 roof 的策略处理近似重复, reject 时失败, report 时记下已有的条目，上传仍然新建条目
+func (e *Entry) checkNearDup(roof string, mw MetaWrapper) error {
+	policy := config.GetNearDup(roof)
+	if policy == "" {
+		return nil
+	}
+	phash := e.perceptualHash()
+	if phash == "" {
+		return nil
+	}
+	a, err := mw.Similar(phash, config.Current().NearDupDistance, nearDupRows)
+	if err != nil {
+		logger().Infow("search similar fail", "roof", roof, "phash", phash, "err", err)
+		return nil
+	}
+	if len(a) == 0 {
+		return nil
+	}
+	near := a[0]
+	logger().Infow("near duplicate", "roof", roof, "policy", policy, "id", near.Id, "distance", near.Distance, "count", len(a))
+	if policy == config.NearDupReject {
+		return fmt.Errorf("%w: %s, distance %d", ErrNearDuplicate, near.Id, near.Distance)
+	}
+	e.Near = make([]*NearItem, len(a))
+	for i, it := range a {
+		e.Near[i] = &NearItem{ID: it.Id, Path: it.Path, Distance: it.Distance}
+	}
+	return nil
+}
+
 func (e *Entry) _save(roof string) (err error) {
 	en := config.GetEngine(roof)
 	log.Printf("start save %s to engine %s", e.Id, en)
@@ -252,6 +511,11 @@ func (e *Entry) _save(roof string) (err error) {
 	}
 	log.Printf("engine push %s ok", e.Id)
 
+	if err = e.pushRaw(roof); err != nil {
+		logger().Warnw("push raw fail", "id", e.Id, "err", err)
+		return
+	}
+
 	mw := NewMetaWrapper(roof)
 	if err = mw.SetDone(e.Id.String(), e.sev); err != nil {
 		logger().Infow("setDone fail", "entry", e)
@@ -267,10 +531,11 @@ func (e *Entry) _save(roof string) (err error) {
 
 func (e *Entry) reset() {
 	e.b = []byte{}
+	e.raw = nil
 }
 
 func (e *Entry) origFullname() string {
-	thumbRoot := path.Join(config.Current.CacheRoot, "thumb")
+	thumbRoot := path.Join(config.Current().CacheRoot, "thumb")
 	return path.Join(thumbRoot, "orig", storedPath(e.Path))
 }
 
@@ -288,7 +553,7 @@ func (e *Entry) StoredMeta() cdb.Meta {
 
 // URI ..
 func (e *Entry) URI(sizeOp string) string {
-	return GetURI(sizeOp + "/" + e.Path)
+	return GetURI(e.roof(), sizeOp+"/"+e.Path)
 }
 
 func getItemCat(roof string) string {
@@ -298,6 +563,17 @@ func getItemCat(roof string) string {
 	return CatStore
 }
 
+// key 文件在引擎中的 key
+func (e *mapItem) key(roof string) backend.Key {
+	cat := getItemCat(roof)
+	if v, ok := e.sev.Get("cat"); ok {
+		if s, ok2 := v.(string); ok2 {
+			cat = s
+		}
+	}
+	return backend.Key{ID: e.Path, Cat: cat}
+}
+
 // pullWith pull blob from engine with key path
 func (e *mapItem) pullWith(roof string) (data []byte, err error) {
 	logger().Infow("pulling", "roof", roof, "path", e.Path)
@@ -307,14 +583,8 @@ func (e *mapItem) pullWith(roof string) (data []byte, err error) {
 		logger().Warnw("farmEngine fail", "roof", roof, "err", err)
 		return
 	}
-	cat := getItemCat(roof)
-	if v, ok := e.sev.Get("cat"); ok {
-		if s, ok2 := v.(string); ok2 {
-			cat = s
-		}
-	}
 	// var data []byte
-	key := backend.Key{ID: e.Path, Cat: cat}
+	key := e.key(roof)
 	data, err = em.Get(key)
 	if err != nil {
 		logger().Warnw("get fail", "roof", roof, "key", key, "err", err)
@@ -343,9 +613,43 @@ func (e *Entry) PushTo(roof string) (sev cdb.Meta, err error) {
 	return
 }
 
+// RawName 保存的原始文件名，没有时为空
+func (e *Entry) RawName() string {
+	if v, ok := e.Hashes.Get("raw"); ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// readRaw 读取未经处理的原始文件，只在 roof 保留原始文件时调用
+func (e *Entry) readRaw(rs io.ReadSeeker) (err error) {
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	e.raw, err = io.ReadAll(rs)
+	return
+}
+
+// pushRaw 保存未经处理的原始文件
+func (e *Entry) pushRaw(roof string) error {
+	name := e.RawName()
+	if name == "" || len(e.raw) == 0 {
+		return nil
+	}
+	em, err := backend.FarmEngine(roof)
+	if err != nil {
+		return err
+	}
+	_, err = em.Put(backend.Key{ID: name, Cat: getRawCat(roof)}, e.raw, cdb.Meta{"name": e.Name, "size": len(e.raw)})
+	return err
+}
+
 func filterImageAttr(roof string, ia *iimg.Attr) (wopt *iimg.WriteOption, err error) {
+	sec := config.GetSection(roof)
 
-	maxQuality := config.Current.MaxQuality
+	maxQuality := sec.MaxQuality
 	if ia.Quality > 0 && maxQuality > 0 {
 		if ia.Quality > maxQuality {
 			log.Printf("jpeg quality %d is too high, set to %d", ia.Quality, maxQuality)
@@ -355,16 +659,16 @@ func filterImageAttr(roof string, ia *iimg.Attr) (wopt *iimg.WriteOption, err er
 		}
 	}
 
-	maxWidth := config.Current.MaxWidth
-	maxHeight := config.Current.MaxHeight
+	maxWidth := sec.MaxWidth
+	maxHeight := sec.MaxHeight
 	if ia.Width > maxWidth || ia.Height > maxHeight {
 		logger().Infow("dimension warning", "maxWidth", maxWidth, "maxHeight", maxHeight, "ia", ia)
-		err = fmt.Errorf("dimension %dx%d of %s is too big", ia.Width, ia.Height, ia.Ext)
+		err = fmt.Errorf("%w: dimension %dx%d of %s", ErrTooLarge, ia.Width, ia.Height, ia.Ext)
 		return
 	}
 
-	minWidth := config.Current.MinWidth
-	minHeight := config.Current.MinHeight
+	minWidth := sec.MinWidth
+	minHeight := sec.MinHeight
 	if ia.Width < minWidth || ia.Height < minHeight {
 		err = fmt.Errorf("dimension %dx%d of %s is too small", ia.Width, ia.Height, ia.Ext)
 		return
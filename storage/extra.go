@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"io"
+
+	"github.com/go-imsto/imsto/storage/imagio"
+	cdb "github.com/go-imsto/imsto/storage/types"
+)
+
+// keys of Entry.Extra
+const (
+	ExtraBlurHash = "blurhash"
+	ExtraLQIP     = "lqip"
+	ExtraColor    = "color"   // dominant color, #rrggbb
+	ExtraPalette  = "palette" // []#rrggbb, dominant first
+	ExtraLabs     = "labs"    // [][L,a,b] of palette, for searching
+	ExtraPHash    = "phash"   // dHash of source image, hex
+)
+
+const (
+	blurHashX   = 4
+	blurHashY   = 3
+	lqipSize    = 16
+	paletteSize = 5
+)
+
+// decodeSource 只解码一次源图，缩小尺寸和各种附加信息都用它
+func (e *Entry) decodeSource(rs io.ReadSeeker) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	m, _, frames, err := imagio.Decode(rs)
+	if err != nil {
+		logger().Infow("decode source fail", "name", e.Name, "err", err)
+		return
+	}
+	e.src = m
+	e.animated = frames > 1
+}
+
+// perceptualHash 从源图计算感知哈希，已有时直接返回
+func (e *Entry) perceptualHash() string {
+	if s := e.ExtraString(ExtraPHash); s != "" || e.src == nil {
+		return s
+	}
+	if e.Extra == nil {
+		e.Extra = cdb.Meta{}
+	}
+	s := imagio.FormatHash(imagio.DHash(e.src))
+	e.Extra[ExtraPHash] = s
+	return s
+}
+
+// trekExtra 从源图 (或缩小后的) 计算附加信息 (占位图等)，失败只记录日志，不影响入库
+func (e *Entry) trekExtra() {
+	if e.Extra == nil {
+		e.Extra = cdb.Meta{}
+	}
+	m := e.src
+	if m == nil {
+		logger().Infow("no decoded source for extra", "name", e.Name)
+		return
+	}
+	e.perceptualHash()
+
+	if s, err := imagio.BlurHash(m, blurHashX, blurHashY); err == nil {
+		e.Extra[ExtraBlurHash] = s
+	} else {
+		logger().Infow("blurhash fail", "name", e.Name, "err", err)
+	}
+	if s, err := imagio.LQIP(m, lqipSize); err == nil {
+		e.Extra[ExtraLQIP] = s
+	} else {
+		logger().Infow("lqip fail", "name", e.Name, "err", err)
+	}
+	if swatches := imagio.Palette(m, paletteSize); len(swatches) > 0 {
+		palette := make([]string, len(swatches))
+		labs := make([]imagio.Lab, len(swatches))
+		for i, sw := range swatches {
+			palette[i] = imagio.HexColor(sw.Color)
+			labs[i] = imagio.ToLab(sw.Color).Round()
+		}
+		e.Extra[ExtraColor] = palette[0]
+		e.Extra[ExtraPalette] = palette
+		e.Extra[ExtraLabs] = labs
+	}
+}
+
+// ExtraString 读取 Extra 中的字符串值
+func (e *Entry) ExtraString(key string) string {
+	if v, ok := e.Extra.Get(key); ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// extra 用于入库，保证不是 null
+func (e *Entry) extra() cdb.Meta {
+	if e.Extra == nil {
+		return cdb.Meta{}
+	}
+	return e.Extra
+}
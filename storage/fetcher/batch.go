@@ -0,0 +1,169 @@
+package fetcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job 批量抓取的一项
+type Job struct {
+	URI     string   `json:"uri"`
+	Referer string   `json:"referer,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Outcome 一项的结果，也是 journal 的一行
+type Outcome struct {
+	URI   string `json:"uri"`
+	ID    string `json:"id,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+	Tries int    `json:"tries"`
+}
+
+// DoFunc 处理一项，返回条目的 ID 和路径
+type DoFunc func(ctx context.Context, job Job) (id, path string, err error)
+
+// Batch 并发抓取，失败时按指数退避重试，同一主机的请求间隔不少于 HostInterval
+type Batch struct {
+	Concurrency  int
+	Retries      int
+	Backoff      time.Duration // first delay of retry, doubled every time
+	HostInterval time.Duration
+	Retryable    func(error) bool // default is Temporary
+	Do           DoFunc
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// Run 处理 jobs 直到关闭或 ctx 结束，每项完成时调用 out，out 不会被并发调用
+func (b *Batch) Run(ctx context.Context, jobs <-chan Job, out func(Outcome)) {
+	n := b.Concurrency
+	if n < 1 {
+		n = 1
+	}
+	if b.Retryable == nil {
+		b.Retryable = Temporary
+	}
+	b.next = make(map[string]time.Time)
+
+	var (
+		wg    sync.WaitGroup
+		outMu sync.Mutex
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				o := b.run(ctx, job)
+				outMu.Lock()
+				out(o)
+				outMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *Batch) run(ctx context.Context, job Job) (o Outcome) {
+	o.URI = job.URI
+	var err error
+	delay := b.Backoff
+	for {
+		o.Tries++
+		if err = b.wait(ctx, hostOf(job.URI)); err == nil {
+			o.ID, o.Path, err = b.Do(ctx, job)
+		}
+		if err == nil || o.Tries > b.Retries || !b.Retryable(err) {
+			break
+		}
+		if err = sleep(ctx, delay); err != nil {
+			break
+		}
+		delay *= 2
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	return
+}
+
+// wait 预约 host 的下一个时间槽并等待
+func (b *Batch) wait(ctx context.Context, host string) error {
+	if b.HostInterval <= 0 {
+		return ctx.Err()
+	}
+	b.mu.Lock()
+	now := time.Now()
+	slot := b.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	b.next[host] = slot.Add(b.HostInterval)
+	b.mu.Unlock()
+	return sleep(ctx, time.Until(slot))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func hostOf(uri string) string {
+	if u, err := url.Parse(uri); err == nil {
+		return strings.ToLower(u.Hostname())
+	}
+	return ""
+}
+
+// ParseJob 解析一行输入，可以是 URL 或 JSON 对象，空行和 # 开头的返回 ok 为 false
+func ParseJob(line string) (job Job, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	if strings.HasPrefix(line, "{") {
+		if err = json.Unmarshal([]byte(line), &job); err != nil {
+			return
+		}
+	} else {
+		job.URI = line
+	}
+	ok = job.URI != ""
+	return
+}
+
+// LoadJournal 读取之前的结果，返回已成功的 URI
+func LoadJournal(r io.Reader) (done map[string]bool, err error) {
+	done = make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var o Outcome
+		if json.Unmarshal(sc.Bytes(), &o) != nil {
+			continue // a partial line of an interrupted run
+		}
+		if o.Error == "" && o.URI != "" {
+			done[o.URI] = true
+		}
+	}
+	err = sc.Err()
+	return
+}
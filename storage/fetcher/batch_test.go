@@ -0,0 +1,146 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   = map[string]int{}
+		running int32
+		peak    int32
+	)
+	b := &Batch{
+		Concurrency: 3,
+		Retries:     2,
+		Backoff:     time.Millisecond,
+		Do: func(ctx context.Context, job Job) (string, string, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			mu.Lock()
+			calls[job.URI]++
+			c := calls[job.URI]
+			mu.Unlock()
+			switch {
+			case strings.HasSuffix(job.URI, "/flaky") && c < 2:
+				return "", "", &StatusError{Code: 503, Status: "503 Service Unavailable"}
+			case strings.HasSuffix(job.URI, "/down"):
+				return "", "", &StatusError{Code: 502, Status: "502 Bad Gateway"}
+			case strings.HasSuffix(job.URI, "/gone"):
+				return "", "", &StatusError{Code: 404, Status: "404 Not Found"}
+			case strings.HasSuffix(job.URI, "/private"):
+				return "", "", fmt.Errorf("%w: 10.0.0.1", ErrForbidden)
+			}
+			return "id" + job.URI[len(job.URI)-1:], "ab/cd/x.jpg", nil
+		},
+	}
+
+	uris := []string{"http://a/1", "http://b/2", "http://c/flaky", "http://d/down", "http://e/gone", "http://f/private", "http://g/3"}
+	jobs := make(chan Job)
+	go func() {
+		for _, u := range uris {
+			jobs <- Job{URI: u}
+		}
+		close(jobs)
+	}()
+	res := map[string]Outcome{}
+	b.Run(context.Background(), jobs, func(o Outcome) { res[o.URI] = o })
+
+	assert.Len(t, res, len(uris))
+	assert.LessOrEqual(t, peak, int32(3))
+	assert.Equal(t, Outcome{URI: "http://a/1", ID: "id1", Path: "ab/cd/x.jpg", Tries: 1}, res["http://a/1"])
+	assert.Equal(t, 2, res["http://c/flaky"].Tries)
+	assert.Empty(t, res["http://c/flaky"].Error)
+	assert.Equal(t, 3, res["http://d/down"].Tries)
+	assert.Contains(t, res["http://d/down"].Error, "502")
+	assert.Equal(t, 1, res["http://e/gone"].Tries)
+	assert.Equal(t, 1, res["http://f/private"].Tries)
+}
+
+func TestBatchHostInterval(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	b := &Batch{
+		Concurrency:  4,
+		HostInterval: 20 * time.Millisecond,
+		Do: func(ctx context.Context, job Job) (string, string, error) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			return "", "", nil
+		},
+	}
+	jobs := make(chan Job, 3)
+	for i := 0; i < 3; i++ {
+		jobs <- Job{URI: fmt.Sprintf("http://same.example.com/%d", i)}
+	}
+	close(jobs)
+	b.Run(context.Background(), jobs, func(Outcome) {})
+
+	assert.Len(t, times, 3)
+	first, last := times[0], times[0]
+	for _, ts := range times {
+		if ts.Before(first) {
+			first = ts
+		}
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	assert.GreaterOrEqual(t, last.Sub(first), 35*time.Millisecond)
+}
+
+func TestTemporary(t *testing.T) {
+	assert.True(t, Temporary(&StatusError{Code: 429}))
+	assert.False(t, Temporary(&StatusError{Code: 404}))
+	assert.True(t, errors.Is(&StatusError{Code: 404}, ErrStatus))
+	assert.False(t, Temporary(fmt.Errorf("%w: x", ErrTooLarge)))
+	assert.False(t, Temporary(errors.New("decode fail")))
+	assert.False(t, Temporary(nil))
+}
+
+func TestParseJob(t *testing.T) {
+	job, ok, err := ParseJob("  http://a/1.jpg \n")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Job{URI: "http://a/1.jpg"}, job)
+
+	job, ok, err = ParseJob(`{"uri":"http://a/2.jpg","referer":"http://a/","tags":["x"]}`)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Job{URI: "http://a/2.jpg", Referer: "http://a/", Tags: []string{"x"}}, job)
+
+	for _, s := range []string{"", "# comment", `{"referer":"x"}`} {
+		_, ok, _ = ParseJob(s)
+		assert.False(t, ok, s)
+	}
+	_, _, err = ParseJob("{bad")
+	assert.Error(t, err)
+}
+
+func TestLoadJournal(t *testing.T) {
+	done, err := LoadJournal(strings.NewReader(`{"uri":"http://a/1","id":"x","tries":1}
+{"uri":"http://a/2","error":"boom","tries":3}
+{"uri":"http://a/3","id":"y"`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"http://a/1": true}, done)
+}
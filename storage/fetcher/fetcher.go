@@ -0,0 +1,318 @@
+// Package fetcher 安全地抓取远程图片，防止 SSRF
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errors
+var (
+	ErrForbidden         = errors.New("forbidden address")
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrContentType       = errors.New("unsupported content type")
+	ErrTooLarge          = errors.New("content too large")
+	ErrStatus            = errors.New("unexpected status")
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+)
+
+const (
+	defaultRedirects = 3
+	defaultTimeout   = 25 * time.Second
+	defaultMaxSize   = 2 << 20
+	defaultUserAgent = "imsto-fetcher/1.0"
+)
+
+// 默认禁止的内网、回环、链路本地等地址，允许列表中的 CIDR 可以放开
+var privateNets = mustParseNets(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// StatusError 远程返回的非 200 状态，errors.Is(err, ErrStatus) 为真
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return ErrStatus.Error() + ": " + e.Status
+}
+
+// Is ...
+func (e *StatusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// Temporary 是否值得重试：网络错误，远程 5xx 或 429
+func Temporary(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, pe := range []error{ErrForbidden, ErrTooManyRedirects, ErrUnsupportedScheme, ErrContentType, ErrTooLarge} {
+		if errors.Is(err, pe) {
+			return false
+		}
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Result 抓取的结果
+type Result struct {
+	Data        []byte
+	ContentType string
+	URL         string // final url after redirects
+	Header      http.Header
+	NotModified bool // 304 of a conditional request
+}
+
+// Client ...
+type Client struct {
+	allowHosts   []string
+	allowNets    []*net.IPNet
+	denyHosts    []string
+	denyNets     []*net.IPNet
+	redirects    int
+	timeout      time.Duration
+	maxSize      int64
+	userAgent    string
+	contentTypes []string
+	resolver     *net.Resolver
+
+	hc *http.Client
+}
+
+// New 创建的 Client 可以并发使用，应当复用以保持连接
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		redirects: defaultRedirects,
+		timeout:   defaultTimeout,
+		maxSize:   defaultMaxSize,
+		userAgent: defaultUserAgent,
+		resolver:  net.DefaultResolver,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	c.hc = &http.Client{
+		Timeout: c.timeout,
+		Transport: &http.Transport{
+			Proxy: nil, // never bypass the address check with a proxy
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ip, port, err := c.resolve(ctx, addr)
+				if err != nil {
+					return nil, err
+				}
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: c.timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > c.redirects {
+				return fmt.Errorf("%w: more than %d", ErrTooManyRedirects, c.redirects)
+			}
+			return c.checkURL(req)
+		},
+	}
+	return c, nil
+}
+
+// Get 抓取 uri，header 为额外的请求头，如 Referer, If-None-Match
+func (c *Client) Get(ctx context.Context, uri string, header http.Header) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	if err = c.checkURL(req); err != nil {
+		return nil, err
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	r := &Result{
+		ContentType: res.Header.Get("Content-Type"),
+		URL:         res.Request.URL.String(),
+		Header:      res.Header,
+	}
+	if res.StatusCode == http.StatusNotModified {
+		r.NotModified = true
+		return r, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return r, &StatusError{Code: res.StatusCode, Status: res.Status}
+	}
+	if !c.allowType(r.ContentType) {
+		return r, fmt.Errorf("%w: %q", ErrContentType, r.ContentType)
+	}
+	if res.ContentLength > c.maxSize {
+		return r, fmt.Errorf("%w: content length %d, max is %d", ErrTooLarge, res.ContentLength, c.maxSize)
+	}
+	r.Data, err = io.ReadAll(io.LimitReader(res.Body, c.maxSize+1))
+	if err != nil {
+		return r, err
+	}
+	if int64(len(r.Data)) > c.maxSize {
+		return r, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, c.maxSize)
+	}
+	return r, nil
+}
+
+// CloseIdleConnections 关闭空闲的连接，不再使用时调用
+func (c *Client) CloseIdleConnections() {
+	c.hc.CloseIdleConnections()
+}
+
+// checkURL 检查 scheme 和禁止的主机名，每次跳转都会检查
+func (c *Client) checkURL(req *http.Request) error {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %q", ErrUnsupportedScheme, req.URL.Scheme)
+	}
+	host := req.URL.Hostname()
+	if matchHost(c.denyHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", ErrForbidden, host)
+	}
+	return nil
+}
+
+// resolve 解析主机名并检查每个地址，返回第一个允许的，避免 DNS rebinding
+func (c *Client) resolve(ctx context.Context, addr string) (net.IP, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ias, err := c.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, ia := range ias {
+			ips = append(ips, ia.IP)
+		}
+	}
+	err = fmt.Errorf("%w: no address of %s", ErrForbidden, host)
+	for _, ip := range ips {
+		if err = c.checkIP(host, ip); err == nil {
+			return ip, port, nil
+		}
+	}
+	return nil, "", err
+}
+
+func (c *Client) checkIP(host string, ip net.IP) error {
+	if inNets(c.denyNets, ip) {
+		return fmt.Errorf("%w: %s (%s) is denied", ErrForbidden, host, ip)
+	}
+	ipAllowed := inNets(c.allowNets, ip)
+	if len(c.allowHosts)+len(c.allowNets) > 0 && !ipAllowed && !matchHost(c.allowHosts, host) {
+		return fmt.Errorf("%w: %s (%s) is not allowed", ErrForbidden, host, ip)
+	}
+	if inNets(privateNets, ip) && !ipAllowed {
+		return fmt.Errorf("%w: %s (%s) is private", ErrForbidden, host, ip)
+	}
+	return nil
+}
+
+func (c *Client) allowType(ct string) bool {
+	mt := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	if len(c.contentTypes) == 0 {
+		return strings.HasPrefix(mt, "image/")
+	}
+	for _, t := range c.contentTypes {
+		if t == mt {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost example.com 只匹配自身，.example.com 或 *.example.com 匹配子域名
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, p := range patterns {
+		p = strings.TrimPrefix(p, "*")
+		if strings.HasPrefix(p, ".") {
+			if strings.HasSuffix(host, p) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseList 拆分为主机名和 CIDR，单个 IP 视为 /32 或 /128
+func parseList(items []string) (hosts []string, nets []*net.IPNet, err error) {
+	for _, s := range items {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if strings.Contains(s, "/") {
+			var n *net.IPNet
+			if _, n, err = net.ParseCIDR(s); err != nil {
+				return
+			}
+			nets = append(nets, n)
+			continue
+		}
+		hosts = append(hosts, s)
+	}
+	return
+}
+
+func mustParseNets(items ...string) []*net.IPNet {
+	_, nets, err := parseList(items)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
@@ -0,0 +1,135 @@
+package fetcher
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("X-UA", r.UserAgent())
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("GIF89a"))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(make([]byte, 100))
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for i := 0; i < 10; i++ {
+			_, _ = w.Write(make([]byte, 10))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/jump", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/img", http.StatusFound)
+	})
+	mux.HandleFunc("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGet(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+	ctx := context.Background()
+
+	// loopback is denied by default
+	c, err := New()
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, ts.URL+"/img", nil)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	c, err = New(WithAllow("127.0.0.1"), WithMaxSize(50), WithUserAgent("imsto-test"))
+	assert.NoError(t, err)
+	r, err := c.Get(ctx, ts.URL+"/img", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", r.ContentType)
+	assert.Equal(t, "imsto-test", r.Header.Get("X-UA"))
+
+	r, err = c.Get(ctx, ts.URL+"/jump", nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(r.URL, "/img"))
+
+	r, err = c.Get(ctx, ts.URL+"/etag", nil)
+	assert.NoError(t, err)
+	assert.False(t, r.NotModified)
+	assert.Equal(t, "GIF89a", string(r.Data))
+	r, err = c.Get(ctx, ts.URL+"/etag", http.Header{"If-None-Match": {r.Header.Get("ETag")}})
+	assert.NoError(t, err)
+	assert.True(t, r.NotModified)
+	assert.Empty(t, r.Data)
+
+	_, err = c.Get(ctx, ts.URL+"/html", nil)
+	assert.ErrorIs(t, err, ErrContentType)
+	_, err = c.Get(ctx, ts.URL+"/big", nil)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = c.Get(ctx, ts.URL+"/chunked", nil)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = c.Get(ctx, ts.URL+"/loop", nil)
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+	_, err = c.Get(ctx, ts.URL+"/metadata", nil)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = c.Get(ctx, ts.URL+"/missing", nil)
+	assert.ErrorIs(t, err, ErrStatus)
+	_, err = c.Get(ctx, "file:///etc/passwd", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+
+	// deny wins over allow
+	c, err = New(WithAllow("127.0.0.0/8"), WithDeny("127.0.0.1"))
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, ts.URL+"/img", nil)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	c, err = New(WithAllow("127.0.0.1"), WithContentTypes("image/jpeg"))
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, ts.URL+"/img", nil)
+	assert.ErrorIs(t, err, ErrContentType)
+
+	_, err = New(WithDeny("10.0.0.0/33"))
+	assert.Error(t, err)
+}
+
+func TestCheckIP(t *testing.T) {
+	c, err := New(WithAllow(".example.com", "10.1.0.0/16"), WithDeny("bad.example.com"))
+	assert.NoError(t, err)
+
+	pub := net.ParseIP("93.184.216.34")
+	assert.NoError(t, c.checkIP("img.example.com", pub))
+	assert.ErrorIs(t, c.checkIP("example.org", pub), ErrForbidden)
+	assert.NoError(t, c.checkIP("intra", net.ParseIP("10.1.2.3")))
+	// an allowed name resolving to a private address is still blocked
+	assert.ErrorIs(t, c.checkIP("img.example.com", net.ParseIP("169.254.169.254")), ErrForbidden)
+	assert.ErrorIs(t, c.checkIP("img.example.com", net.ParseIP("::1")), ErrForbidden)
+
+	req := httptest.NewRequest(http.MethodGet, "http://bad.example.com/a.jpg", nil)
+	assert.ErrorIs(t, c.checkURL(req), ErrForbidden)
+
+	assert.True(t, matchHost([]string{"*.example.com"}, "a.b.example.com"))
+	assert.False(t, matchHost([]string{"example.com"}, "a.example.com"))
+}
@@ -0,0 +1,85 @@
+package fetcher
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Option ...
+type Option func(*Client) error
+
+// WithAllow 允许的主机名或 CIDR，非空时只能访问其中的地址
+func WithAllow(items ...string) Option {
+	return func(c *Client) (err error) {
+		c.allowHosts, c.allowNets, err = parseList(items)
+		return
+	}
+}
+
+// WithDeny 禁止的主机名或 CIDR，优先于允许列表
+func WithDeny(items ...string) Option {
+	return func(c *Client) (err error) {
+		c.denyHosts, c.denyNets, err = parseList(items)
+		return
+	}
+}
+
+// WithRedirects 最多跟随的跳转次数
+func WithRedirects(n int) Option {
+	return func(c *Client) error {
+		if n >= 0 {
+			c.redirects = n
+		}
+		return nil
+	}
+}
+
+// WithTimeout ...
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d > 0 {
+			c.timeout = d
+		}
+		return nil
+	}
+}
+
+// WithMaxSize 最多读取的字节数
+func WithMaxSize(n int64) Option {
+	return func(c *Client) error {
+		if n > 0 {
+			c.maxSize = n
+		}
+		return nil
+	}
+}
+
+// WithUserAgent ...
+func WithUserAgent(ua string) Option {
+	return func(c *Client) error {
+		if ua != "" {
+			c.userAgent = ua
+		}
+		return nil
+	}
+}
+
+// WithContentTypes 允许的 Content-Type，为空时允许所有 image/*
+func WithContentTypes(types ...string) Option {
+	return func(c *Client) error {
+		c.contentTypes = c.contentTypes[:0]
+		for _, t := range types {
+			c.contentTypes = append(c.contentTypes, strings.ToLower(t))
+		}
+		return nil
+	}
+}
+
+// WithResolver 用于测试
+func WithResolver(r *net.Resolver) Option {
+	return func(c *Client) error {
+		c.resolver = r
+		return nil
+	}
+}
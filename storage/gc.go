@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/backend"
+	"github.com/go-imsto/imsto/storage/thumbs"
+	cdb "github.com/go-imsto/imsto/storage/types"
+)
+
+// DefaultGCLimit 一次 gc 最多处理的条目数
+const DefaultGCLimit = 1000
+
+// Garbage 所有 roof 都已删除的条目，文件还在引擎和缓存中
+type Garbage struct {
+	ID      IID       `json:"id"`
+	Path    string    `json:"path"`
+	Roof    string    `json:"roof"` // whose engine holds the file
+	Raw     string    `json:"raw,omitempty"`
+	Deleted time.Time `json:"deleted"`
+	Blobs   []string  `json:"blobs,omitempty"` // keys in the engine
+	Files   []string  `json:"files,omitempty"` // derivatives in cache
+	Error   string    `json:"error,omitempty"`
+
+	sev cdb.Meta
+}
+
+// GCReport 一次 gc 的汇总
+type GCReport struct {
+	DryRun  bool      `json:"dry_run,omitempty"`
+	Before  time.Time `json:"before"`
+	Entries int       `json:"entries"`
+	Blobs   int       `json:"blobs"`
+	Files   int       `json:"files"`
+	Failed  int       `json:"failed"`
+	Trash   int64     `json:"trash"` // expired entries purged from trash
+}
+
+// ListGarbage 删除时间早于 before 的条目，同一文件仍被引用时不列出
+func (mw *MetaWrap) ListGarbage(before time.Time, limit int) (a []*Garbage, err error) {
+	qs := `SELECT m.id, m.path, m.sev, COALESCE(NULLIF(m.home, ''), max(d.roof)), max(d.deleted)
+	, COALESCE(max(d.hashes->>'raw'), '')
+	FROM map_template m JOIN meta__deleted d ON d.id = m.id OR m.id::text = ANY(d.ids::text[])
+	WHERE m.status = 1
+	 AND NOT EXISTS (SELECT 1 FROM map_template l WHERE l.path = m.path AND l.status = 0)
+	GROUP BY m.id, m.path, m.sev, m.home
+	HAVING max(d.deleted) < $1
+	ORDER BY 5, 1`
+	if limit > 0 {
+		qs += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	var rows *sql.Rows
+	rows, err = mw.getDb().Query(qs, before)
+	if err != nil {
+		logger().Warnw("list garbage fail", "before", before, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		g := new(Garbage)
+		if err = rows.Scan(&g.ID, &g.Path, &g.sev, &g.Roof, &g.Deleted, &g.Raw); err != nil {
+			return
+		}
+		a = append(a, g)
+	}
+	err = rows.Err()
+	return
+}
+
+// RemoveGarbage 文件清除后删除映射
+func (mw *MetaWrap) RemoveGarbage(id string) error {
+	return mw.withTxQuery(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM "+tableMap(id)+" WHERE id = $1 AND status = 1", id)
+		return err
+	})
+}
+
+// GC 清除删除超过 grace 的条目在引擎中的文件和本地缓存，以及回收站中过期的条目，dryRun 时只报告
+func GC(ctx context.Context, grace time.Duration, limit int, dryRun bool, out func(*Garbage)) (*GCReport, error) {
+	rep := &GCReport{DryRun: dryRun, Before: time.Now().Add(-grace)}
+	mw := NewMetaWrapper(commonRoof)
+	items, err := mw.ListGarbage(rep.Before, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range items {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if err := collect(mw, g, dryRun); err != nil {
+			g.Error = err.Error()
+			rep.Failed++
+			logger().Warnw("gc fail", "id", g.ID, "roof", g.Roof, "err", err)
+		} else {
+			rep.Entries++
+			rep.Blobs += len(g.Blobs)
+			rep.Files += len(g.Files)
+		}
+		if out != nil {
+			out(g)
+		}
+	}
+	if err == nil && !dryRun {
+		rep.Trash, err = mw.PurgeTrash(time.Now().Add(-config.Current().TrashRetention))
+	}
+	logger().Infow("gc done", "report", rep)
+	return rep, err
+}
+
+// collect 清除一个条目，文件已经不存在时也算成功
+func collect(mw MetaWrapper, g *Garbage, dryRun bool) error {
+	if config.GetEngine(g.Roof) == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidRoof, g.Roof)
+	}
+	em, err := backend.FarmEngine(g.Roof)
+	if err != nil {
+		return err
+	}
+	item := &mapItem{Path: g.Path, sev: g.sev}
+	keys := []backend.Key{item.key(g.Roof)}
+	if g.Raw != "" {
+		keys = append(keys, backend.Key{ID: g.Raw, Cat: getRawCat(g.Roof)})
+	}
+	for _, k := range keys {
+		g.Blobs = append(g.Blobs, k.Path())
+	}
+	if g.Files, err = thumbs.Derivatives(config.Current().CacheRoot, g.ID.String()); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	for _, k := range keys {
+		if err = em.Delete(k); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	for _, name := range g.Files {
+		if err = os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return mw.RemoveGarbage(g.ID.String())
+}
+
+// purgeCached 删除缓存中条目的原图和缩略图，删除的条目不再从缓存提供，失败只记录日志
+func purgeCached(id string) {
+	files, err := thumbs.Derivatives(config.Current().CacheRoot, id)
+	if err != nil {
+		logger().Warnw("list cached fail", "id", id, "err", err)
+		return
+	}
+	for _, name := range files {
+		if err = os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger().Warnw("remove cached fail", "name", name, "err", err)
+		}
+	}
+}
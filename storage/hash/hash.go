@@ -1,8 +1,14 @@
 package hash
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/spaolacci/murmur3"
 )
@@ -64,3 +70,29 @@ func combine(h1, h2 uint64, t uint32) []byte {
 		byte(t >> 8), byte(t),
 	}
 }
+
+// ErrMismatch 内容与调用者给出的摘要不符
+var ErrMismatch = errors.New("hash mismatch")
+
+// Verify 校验 Content-MD5 (base64) 和 imsto 哈希 (hex)，为空的不校验
+func Verify(data []byte, contentMD5, imstoHash string) error {
+	if contentMD5 != "" {
+		sum := md5.Sum(data)
+		if want, err := base64.StdEncoding.DecodeString(contentMD5); err != nil || !bytes.Equal(want, sum[:]) {
+			return fmt.Errorf("%w: Content-MD5 %s", ErrMismatch, contentMD5)
+		}
+	}
+	if imstoHash != "" && !strings.EqualFold(imstoHash, SumContent(data)) {
+		return fmt.Errorf("%w: hash %s", ErrMismatch, imstoHash)
+	}
+	return nil
+}
+
+// Valid 是否为 SumContent 格式的哈希: 18 字节的 hex
+func Valid(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -1,6 +1,7 @@
 package hash
 
 import (
+	"crypto/md5"
 	"encoding/base64"
 	"io"
 	"strings"
@@ -42,3 +43,24 @@ func TestHashBlob(t *testing.T) {
 	t.Logf("hash %s", hash)
 	assert.Equal(t, jpegHash, hash)
 }
+
+func TestVerify(t *testing.T) {
+	data := []byte("hello imsto")
+	sum := md5.Sum(data)
+	md := base64.StdEncoding.EncodeToString(sum[:])
+
+	assert.NoError(t, Verify(data, "", ""))
+	assert.NoError(t, Verify(data, md, SumContent(data)))
+	assert.NoError(t, Verify(data, "", strings.ToUpper(SumContent(data))))
+	assert.ErrorIs(t, Verify(data, md, jpegHash), ErrMismatch)
+	assert.ErrorIs(t, Verify(data[1:], md, ""), ErrMismatch)
+	assert.ErrorIs(t, Verify(data, "not base64!", ""), ErrMismatch)
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(jpegHash))
+	assert.True(t, Valid(strings.ToUpper(jpegHash)))
+	assert.False(t, Valid(jpegHash[:34]))
+	assert.False(t, Valid("../"+jpegHash[3:]))
+	assert.False(t, Valid(""))
+}
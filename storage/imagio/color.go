@@ -0,0 +1,205 @@
+package imagio
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	paletteSample = 64 // 提取调色板前先缩到的边长
+)
+
+// ErrInvalidColor 表示无效的十六进制颜色
+var ErrInvalidColor = errors.New("invalid hex color")
+
+// Lab CIE L*a*b* (D65)
+type Lab [3]float64
+
+// DeltaE CIE76 色差
+func (z Lab) DeltaE(o Lab) float64 {
+	return math.Sqrt((z[0]-o[0])*(z[0]-o[0]) + (z[1]-o[1])*(z[1]-o[1]) + (z[2]-o[2])*(z[2]-o[2]))
+}
+
+// Round 保留两位小数，便于存储
+func (z Lab) Round() Lab {
+	for i := range z {
+		z[i] = math.Round(z[i]*100) / 100
+	}
+	return z
+}
+
+// HexColor 转为 #rrggbb 格式
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// ParseHexColor 解析 #rrggbb, rrggbb 或 #rgb 格式
+func ParseHexColor(s string) (c color.RGBA, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		err = fmt.Errorf("%w: %q", ErrInvalidColor, s)
+		return
+	}
+	var v uint64
+	v, err = strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		err = fmt.Errorf("%w: %q", ErrInvalidColor, s)
+		return
+	}
+	c = color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+	return
+}
+
+// ToLab 把 sRGB 颜色转为 Lab
+func ToLab(c color.RGBA) Lab {
+	r := sRGBToLinear(uint32(c.R))
+	g := sRGBToLinear(uint32(c.G))
+	b := sRGBToLinear(uint32(c.B))
+
+	x := (r*0.4124564 + g*0.3575761 + b*0.1804375) / 0.95047
+	y := r*0.2126729 + g*0.7151522 + b*0.0721750
+	z := (r*0.0193339 + g*0.1191920 + b*0.9503041) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return Lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// Swatch 调色板中的一个颜色及其像素占比
+type Swatch struct {
+	Color  color.RGBA
+	Weight float64
+}
+
+type colorBox struct {
+	pixels []color.RGBA
+}
+
+func (b *colorBox) widest() (ch int, span uint8) {
+	lo := [3]uint8{255, 255, 255}
+	var hi [3]uint8
+	for _, p := range b.pixels {
+		v := [3]uint8{p.R, p.G, p.B}
+		for i := range v {
+			if v[i] < lo[i] {
+				lo[i] = v[i]
+			}
+			if v[i] > hi[i] {
+				hi[i] = v[i]
+			}
+		}
+	}
+	for i := range hi {
+		if hi[i]-lo[i] >= span {
+			ch, span = i, hi[i]-lo[i]
+		}
+	}
+	return
+}
+
+func (b *colorBox) average() color.RGBA {
+	var r, g, bl int
+	for _, p := range b.pixels {
+		r += int(p.R)
+		g += int(p.G)
+		bl += int(p.B)
+	}
+	n := len(b.pixels)
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255}
+}
+
+// Palette 用中位切分法提取最多 k 个主要颜色，按占比降序，第一个即主色
+func Palette(m image.Image, k int) []Swatch {
+	if k < 1 {
+		return nil
+	}
+	m = resize.Thumbnail(paletteSample, paletteSample, m, resize.Bilinear)
+	bounds := m.Bounds()
+	pixels := make([]color.RGBA, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 128 { // 忽略透明像素
+				continue
+			}
+			pixels = append(pixels, color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []*colorBox{{pixels: pixels}}
+	for len(boxes) < k {
+		idx, ch := -1, 0
+		var best uint8
+		for i, b := range boxes {
+			if len(b.pixels) < 2 {
+				continue
+			}
+			if c, span := b.widest(); span > best || idx < 0 {
+				idx, ch, best = i, c, span
+			}
+		}
+		if idx < 0 || best == 0 {
+			break
+		}
+		b := boxes[idx]
+		sort.Slice(b.pixels, func(i, j int) bool {
+			return channel(b.pixels[i], ch) < channel(b.pixels[j], ch)
+		})
+		mid := splitAt(b.pixels, ch)
+		boxes[idx] = &colorBox{pixels: b.pixels[:mid]}
+		boxes = append(boxes, &colorBox{pixels: b.pixels[mid:]})
+	}
+
+	total := float64(len(pixels))
+	out := make([]Swatch, len(boxes))
+	for i, b := range boxes {
+		out[i] = Swatch{Color: b.average(), Weight: float64(len(b.pixels)) / total}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Weight > out[j].Weight })
+	return out
+}
+
+// splitAt 从中位数附近找一个切分点，不把同值的像素分到两边
+func splitAt(pixels []color.RGBA, ch int) int {
+	mid := len(pixels) / 2
+	for i := mid; i < len(pixels); i++ {
+		if channel(pixels[i], ch) != channel(pixels[i-1], ch) {
+			return i
+		}
+	}
+	for i := mid - 1; i > 0; i-- {
+		if channel(pixels[i], ch) != channel(pixels[i-1], ch) {
+			return i
+		}
+	}
+	return mid
+}
+
+func channel(c color.RGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
+}
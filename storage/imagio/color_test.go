@@ -0,0 +1,52 @@
+package imagio
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHexColor(t *testing.T) {
+	c, err := ParseHexColor("#f00")
+	assert.NoError(t, err)
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, c)
+
+	c, err = ParseHexColor("1e90ff")
+	assert.NoError(t, err)
+	assert.Equal(t, "#1e90ff", HexColor(c))
+
+	for _, s := range []string{"", "#12", "zzzzzz", "#1234567"} {
+		_, err = ParseHexColor(s)
+		assert.ErrorIs(t, err, ErrInvalidColor, s)
+	}
+}
+
+func TestToLab(t *testing.T) {
+	white := ToLab(color.RGBA{255, 255, 255, 255}).Round()
+	assert.InDelta(t, 100, white[0], 0.01)
+	assert.InDelta(t, 0, white[1], 0.01)
+	assert.InDelta(t, 0, white[2], 0.01)
+
+	black := ToLab(color.RGBA{0, 0, 0, 255})
+	assert.InDelta(t, 100, white.DeltaE(black), 0.01)
+
+	red := ToLab(color.RGBA{255, 0, 0, 255}).Round()
+	assert.Equal(t, Lab{53.24, 80.09, 67.2}, red)
+}
+
+func TestPalette(t *testing.T) {
+	m := newUniform(64, 64, color.RGBA{255, 0, 0, 255})
+	draw.Draw(m, image.Rect(0, 48, 64, 64), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+
+	p := Palette(m, 5)
+	assert.Len(t, p, 2)
+	assert.Equal(t, "#ff0000", HexColor(p[0].Color))
+	assert.InDelta(t, 0.75, p[0].Weight, 0.001)
+	assert.Equal(t, "#0000ff", HexColor(p[1].Color))
+
+	assert.Empty(t, Palette(newUniform(8, 8, color.RGBA{}), 5))
+	assert.Nil(t, Palette(m, 0))
+}
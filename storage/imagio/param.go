@@ -19,7 +19,7 @@ const (
 )
 
 const (
-	ptImagePath  = `(?P<tp>[a-z_][a-z0-9_-]*)/(?P<size>[scwh]\d{2,4}(?P<x>x\d{2,4})?|orig)(?P<mop>[a-z])?/(?P<t1>[a-z0-9]{2})/?(?P<t2>[a-z0-9]{2})/?(?P<t3>[a-z0-9]{5,36})\.(?P<ext>gif|jpg|jpeg|png|webp)$`
+	ptImagePath  = `(?P<tp>[a-z_][a-z0-9_-]*)/(?P<size>[scwh]\d{2,4}(?P<x>x\d{2,4})?|orig|raw)(?P<mop>[a-z])?/(?P<t1>[a-z0-9]{2})/?(?P<t2>[a-z0-9]{2})/?(?P<t3>[a-z0-9]{5,36})\.(?P<ext>gif|jpg|jpeg|png|webp)$`
 	ptImageSize  = `(?P<size>[scwh]\d{2,4}(?P<x>x\d{2,4})?)(?P<mop>[a-z])?`
 	minDimension = 20   // 最小尺寸
 	maxDimension = 9999 // 最大尺寸
@@ -39,6 +39,7 @@ type harg map[string]string
 type Param struct {
 	ID     imid.IID `json:"id"`
 	IsOrig bool     `json:"isOrig"`
+	IsRaw  bool     `json:"isRaw,omitempty"` // untouched upload
 	Path   string   `json:"path"`
 	SizeOp string   `json:"size"`
 	Mop    string   `json:"mop,omitempty"`
@@ -88,10 +89,11 @@ func ParseFromPath(uri string) (p *Param, err error) {
 		Mop:    m["mop"],
 		Ext:    m["ext"],
 		IsOrig: m["size"] == "orig",
+		IsRaw:  m["size"] == "raw",
 		Name:   name,
 		Roof:   m["tp"],
 	}
-	if !p.IsOrig {
+	if !p.IsOrig && !p.IsRaw {
 		p.Mode, p.Width, p.Height = parseSizeOp(p.SizeOp)
 	}
 
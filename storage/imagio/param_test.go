@@ -17,6 +17,13 @@ func TestParam(t *testing.T) {
 	assert.Equal(t, 120, int(p.Width))
 	assert.Equal(t, 120, int(p.Height))
 	assert.Equal(t, "show", p.Roof)
+
+	p, err = ParseFromPath("/show/raw/bd/ou/ymx4a7ro.png")
+	assert.NoError(t, err)
+	assert.True(t, p.IsRaw)
+	assert.False(t, p.IsOrig)
+	assert.Equal(t, "bdouymx4a7ro.png", p.Name)
+	assert.Zero(t, p.Width)
 }
 
 func TestParseSize(t *testing.T) {
@@ -0,0 +1,48 @@
+package imagio
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// DHash 计算 64 位差异哈希 (dHash)，对缩放、重新编码不敏感
+func DHash(m image.Image) uint64 {
+	small := resize.Resize(9, 8, m, resize.Bilinear)
+	b := small.Bounds()
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := gray(small.At(b.Min.X+x, b.Min.Y+y))
+			right := gray(small.At(b.Min.X+x+1, b.Min.Y+y))
+			h <<= 1
+			if left > right {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+func gray(c color.Color) uint16 {
+	return color.Gray16Model.Convert(c).(color.Gray16).Y
+}
+
+// HashDistance 两个哈希的汉明距离
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash 转为 16 位十六进制
+func FormatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHash 解析 FormatHash 的结果
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
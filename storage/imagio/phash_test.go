@@ -0,0 +1,43 @@
+package imagio
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/nfnt/resize"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGradient(w, h int, reverse bool) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*7 + y*3) * 255 / (w*7 + h*3))
+			if reverse {
+				v = 255 - v
+			}
+			if (x/(w/4)+y/(h/4))%2 == 0 {
+				v /= 2
+			}
+			m.Set(x, y, color.RGBA{v, v, 255 - v, 255})
+		}
+	}
+	return m
+}
+
+func TestDHash(t *testing.T) {
+	m := newGradient(400, 300, false)
+	h1 := DHash(m)
+	h2 := DHash(resize.Resize(160, 120, m, resize.Bicubic))
+	assert.LessOrEqual(t, HashDistance(h1, h2), 4)
+
+	h3 := DHash(newGradient(400, 300, true))
+	assert.Greater(t, HashDistance(h1, h3), 10)
+
+	s := FormatHash(h1)
+	assert.Len(t, s, 16)
+	h, err := ParseHash(s)
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h)
+}
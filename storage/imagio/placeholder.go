@@ -0,0 +1,164 @@
+package imagio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	_ "image/gif" // decode only
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/webp" // decode only
+)
+
+const (
+	blurSample  = 64 // 计算 BlurHash 前先缩到的边长
+	lqipQuality = 40
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// ErrInvalidComponents 表示 BlurHash 的分量数超出范围 (1..9)
+var ErrInvalidComponents = errors.New("blurhash components must be between 1 and 9")
+
+// BlurHash 按 https://blurha.sh 的算法计算占位串
+func BlurHash(m image.Image, xComp, yComp int) (string, error) {
+	if xComp < 1 || xComp > 9 || yComp < 1 || yComp > 9 {
+		return "", ErrInvalidComponents
+	}
+	m = resize.Thumbnail(blurSample, blurSample, m, resize.Bilinear)
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return "", errors.New("empty image")
+	}
+
+	factors := make([][3]float64, 0, xComp*yComp)
+	for j := 0; j < yComp; j++ {
+		for i := 0; i < xComp; i++ {
+			factors = append(factors, multiplyBasis(m, i, j))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((xComp-1)+(yComp-1)*9, 1))
+
+	maxValue := 1.0
+	ac := factors[1:]
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		sb.WriteString(encode83(quantisedMax, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+
+	sb.WriteString(encode83(encodeDC(factors[0]), 4))
+	for _, f := range ac {
+		sb.WriteString(encode83(encodeAC(f, maxValue), 2))
+	}
+	return sb.String(), nil
+}
+
+func multiplyBasis(m image.Image, i, j int) (f [3]float64) {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+			r, g, bl, _ := m.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			f[0] += basis * sRGBToLinear(r>>8)
+			f[1] += basis * sRGBToLinear(g>>8)
+			f[2] += basis * sRGBToLinear(bl>>8)
+		}
+	}
+	scale := normalisation / float64(w*h)
+	f[0] *= scale
+	f[1] *= scale
+	f[2] *= scale
+	return
+}
+
+func encodeDC(f [3]float64) int {
+	return linearToSRGB(f[0])<<16 + linearToSRGB(f[1])<<8 + linearToSRGB(f[2])
+}
+
+func encodeAC(f [3]float64, maxValue float64) int {
+	quant := func(v float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+	}
+	return quant(f[0])*19*19 + quant(f[1])*19 + quant(f[2])
+}
+
+func sRGBToLinear(c uint32) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func encode83(value, length int) string {
+	buf := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		buf[i-1] = base83Chars[digit]
+	}
+	return string(buf)
+}
+
+// LQIP 生成一个极小尺寸的 data URI 占位图，有透明通道的用 PNG，否则用 JPEG
+func LQIP(m image.Image, size uint) (string, error) {
+	small := resize.Thumbnail(size, size, m, resize.Bilinear)
+
+	var (
+		buf  bytes.Buffer
+		mime string
+		err  error
+	)
+	if IsOpaque(m) {
+		mime = "image/jpeg"
+		err = jpeg.Encode(&buf, small, &jpeg.Options{Quality: lqipQuality})
+	} else {
+		mime = "image/png"
+		err = png.Encode(&buf, small)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// IsOpaque 图片是否完全不透明
+func IsOpaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package imagio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newUniform(w, h int, c color.Color) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	return m
+}
+
+// newRamp 参考向量用的渐变图，不超过 blurSample 时不会缩小
+func newRamp(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 10), 128, 255})
+		}
+	}
+	return m
+}
+
+func TestBlurHash(t *testing.T) {
+	// 参考值由 woltapp/blurhash 的编码算法独立计算
+	for _, c := range []struct {
+		m        image.Image
+		xComp    int
+		yComp    int
+		expected string
+	}{
+		{newRamp(32, 24), 4, 3, "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+		{newRamp(32, 24), 1, 1, "00H27k"},
+		{newUniform(8, 6, color.RGBA{255, 0, 0, 255}), 1, 1, "00TI:j"},
+	} {
+		s, err := BlurHash(c.m, c.xComp, c.yComp)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, s)
+	}
+
+	s, err := BlurHash(newUniform(1600, 1200, color.White), 4, 3)
+	assert.NoError(t, err)
+	assert.Len(t, s, 4+2*4*3)
+
+	_, err = BlurHash(newUniform(8, 6, color.White), 0, 10)
+	assert.ErrorIs(t, err, ErrInvalidComponents)
+}
+
+func TestLQIP(t *testing.T) {
+	s, err := LQIP(newUniform(400, 300, color.RGBA{0, 128, 0, 255}), 16)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(s, "data:image/jpeg;base64,"))
+
+	s, err = LQIP(newUniform(400, 300, color.RGBA{0, 0, 0, 0}), 16)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(s, "data:image/png;base64,"))
+}
+
+func TestIsOpaque(t *testing.T) {
+	assert.True(t, IsOpaque(newUniform(4, 4, color.RGBA{255, 0, 0, 255})))
+	assert.False(t, IsOpaque(newUniform(4, 4, color.RGBA{0, 0, 0, 0})))
+}
+
+// 1x1 lossless webp
+const webpData = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func TestDecodeWebP(t *testing.T) {
+	b, err := base64.StdEncoding.DecodeString(webpData)
+	assert.NoError(t, err)
+	m, format, err := image.Decode(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, "webp", format)
+	assert.Equal(t, 1, m.Bounds().Dx())
+}
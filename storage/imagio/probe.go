@@ -0,0 +1,94 @@
+package imagio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ErrUnknownFormat 无法识别的图片格式
+var ErrUnknownFormat = errors.New("unknown image format")
+
+// Header 从文件头读到的格式和声明的尺寸
+type Header struct {
+	Format string // jpeg, png, gif, webp
+	Width  int
+	Height int
+}
+
+// Pixels 像素总数
+func (h Header) Pixels() uint64 {
+	return uint64(h.Width) * uint64(h.Height)
+}
+
+// Probe 只读取文件头，识别格式和声明的尺寸，不解码图像数据
+func Probe(r io.Reader) (h Header, err error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(30)
+	h.Format = sniff(magic)
+	switch h.Format {
+	case "":
+		err = ErrUnknownFormat
+		return
+	case "webp":
+		return probeWebP(magic)
+	}
+	var cfg image.Config
+	cfg, _, err = image.DecodeConfig(br)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrUnknownFormat, err)
+		return
+	}
+	h.Width, h.Height = cfg.Width, cfg.Height
+	return
+}
+
+func sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return "gif"
+	case len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// probeWebP 读取 VP8X, VP8 或 VP8L 块中的尺寸
+func probeWebP(b []byte) (h Header, err error) {
+	h.Format = "webp"
+	if len(b) < 30 {
+		err = fmt.Errorf("%w: short webp header", ErrUnknownFormat)
+		return
+	}
+	switch string(b[12:16]) {
+	case "VP8X":
+		h.Width = 1 + (int(b[24]) | int(b[25])<<8 | int(b[26])<<16)
+		h.Height = 1 + (int(b[27]) | int(b[28])<<8 | int(b[29])<<16)
+	case "VP8 ":
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			err = fmt.Errorf("%w: bad vp8 start code", ErrUnknownFormat)
+			return
+		}
+		h.Width = int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff)
+		h.Height = int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff)
+	case "VP8L":
+		if b[20] != 0x2f {
+			err = fmt.Errorf("%w: bad vp8l signature", ErrUnknownFormat)
+			return
+		}
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		h.Width = int(bits&0x3fff) + 1
+		h.Height = int(bits>>14&0x3fff) + 1
+	default:
+		err = fmt.Errorf("%w: unknown webp chunk %q", ErrUnknownFormat, b[12:16])
+	}
+	return
+}
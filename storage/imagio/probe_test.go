@@ -0,0 +1,45 @@
+package imagio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, newUniform(3, 2, color.RGBA{255, 0, 0, 255})))
+	b := buf.Bytes()
+
+	h, err := Probe(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, Header{Format: "png", Width: 3, Height: 2}, h)
+
+	// declare a 50000x50000 png, only the header is read
+	bomb := append([]byte{}, b[:33]...)
+	binary.BigEndian.PutUint32(bomb[16:], 50000)
+	binary.BigEndian.PutUint32(bomb[20:], 50000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+	h, err = Probe(bytes.NewReader(bomb))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500000000), h.Pixels())
+
+	// VP8X
+	webp := make([]byte, 30)
+	copy(webp, "RIFF\x00\x00\x00\x00WEBPVP8X")
+	webp[24], webp[25] = 0x3f, 0x01 // 320
+	webp[27], webp[28] = 0xef, 0x00 // 240
+	h, err = Probe(bytes.NewReader(webp))
+	assert.NoError(t, err)
+	assert.Equal(t, Header{Format: "webp", Width: 320, Height: 240}, h)
+
+	for _, s := range []string{"", "BM\x00\x00", "<svg></svg>", "\x89PNG\r\n\x1a\nbroken"} {
+		_, err = Probe(bytes.NewReader([]byte(s)))
+		assert.ErrorIs(t, err, ErrUnknownFormat, s)
+	}
+}
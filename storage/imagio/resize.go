@@ -0,0 +1,65 @@
+package imagio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// ErrEncodeFormat 不能编码的格式
+var ErrEncodeFormat = errors.New("unsupported format to encode")
+
+// Fit 等比缩小到 maxWidth x maxHeight 以内，不会放大
+func Fit(m image.Image, maxWidth, maxHeight uint) image.Image {
+	return resize.Thumbnail(maxWidth, maxHeight, m, resize.Lanczos3)
+}
+
+// Encode 按扩展名 (可以没有点) 编码，只支持 jpeg, png 和 gif (单帧)，quality 只用于 jpeg，0 为默认
+func Encode(w io.Writer, m image.Image, ext string, quality uint8) error {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "jpg", "jpeg":
+		q := jpeg.DefaultQuality
+		if quality > 0 {
+			q = int(quality)
+		}
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: q})
+	case "png":
+		return png.Encode(w, m)
+	case "gif":
+		return gif.Encode(w, m, nil)
+	}
+	return fmt.Errorf("%w: %q", ErrEncodeFormat, ext)
+}
+
+// CanEncode 是否能用 Encode 编码
+func CanEncode(ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "jpg", "jpeg", "png", "gif":
+		return true
+	}
+	return false
+}
+
+// Decode 解码图片，gif 只解码一次并返回帧数，其他的帧数为 1
+func Decode(r io.Reader) (m image.Image, format string, frames int, err error) {
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(4); string(b) == "GIF8" {
+		var g *gif.GIF
+		if g, err = gif.DecodeAll(br); err != nil {
+			return
+		}
+		return g.Image[0], "gif", len(g.Image), nil
+	}
+	if m, format, err = image.Decode(br); err != nil {
+		return
+	}
+	return m, format, 1, nil
+}
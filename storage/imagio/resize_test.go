@@ -0,0 +1,62 @@
+package imagio
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFit(t *testing.T) {
+	m := newUniform(4000, 3000, color.RGBA{0, 128, 255, 255})
+	b := Fit(m, 1600, 1600).Bounds()
+	assert.Equal(t, 1600, b.Dx())
+	assert.Equal(t, 1200, b.Dy())
+
+	b = Fit(m, 5000, 5000).Bounds()
+	assert.Equal(t, 4000, b.Dx())
+}
+
+func TestEncode(t *testing.T) {
+	m := newUniform(8, 8, color.RGBA{255, 0, 0, 255})
+	for _, c := range []struct{ ext, format string }{
+		{".jpg", "jpeg"},
+		{"jpg", "jpeg"},
+		{".gif", "gif"},
+		{".png", "png"},
+	} {
+		var buf bytes.Buffer
+		assert.True(t, CanEncode(c.ext))
+		assert.NoError(t, Encode(&buf, m, c.ext, 80))
+		_, format, err := image.Decode(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, c.format, format)
+	}
+	assert.False(t, CanEncode(".webp"))
+	assert.ErrorIs(t, Encode(io.Discard, m, ".webp", 0), ErrEncodeFormat)
+}
+
+func TestDecode(t *testing.T) {
+	p := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	assert.NoError(t, gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{p, p}, Delay: []int{10, 10}}))
+	m, format, n, err := Decode(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "gif", format)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, p.Bounds(), m.Bounds())
+
+	buf.Reset()
+	assert.NoError(t, Encode(&buf, p, ".png", 0))
+	_, format, n, err = Decode(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 1, n)
+
+	_, _, _, err = Decode(bytes.NewReader([]byte("GIF89a")))
+	assert.Error(t, err)
+}
@@ -1,14 +1,47 @@
 package storage
 
 import (
+	"time"
+
+	"github.com/go-imsto/imsto/storage/imagio"
 	cdb "github.com/go-imsto/imsto/storage/types"
 )
 
+// DefaultColorDelta 颜色过滤默认的色差 (CIE76) 阈值
+const DefaultColorDelta = 10.0
+
 // MetaFilter ...
 type MetaFilter struct {
-	Tags   string
+	Tags   string // tag query, see types.TagQuery
 	App    AppID
 	Author Author
+	Color  string  // hex value, match any color in palette
+	Delta  float64 // max delta-E of Color, zero means DefaultColorDelta
+}
+
+// TagQuery parse Tags, like `cat,dog | bird -nsfw`
+func (f MetaFilter) TagQuery() (cdb.TagQuery, error) {
+	return cdb.ParseTagQuery(f.Tags)
+}
+
+// ColorLab returns Lab of Color if it is valid
+func (f MetaFilter) ColorLab() (lab imagio.Lab, ok bool) {
+	if f.Color == "" {
+		return
+	}
+	c, err := imagio.ParseHexColor(f.Color)
+	if err != nil {
+		return
+	}
+	return imagio.ToLab(c), true
+}
+
+// ColorDelta ...
+func (f MetaFilter) ColorDelta() float64 {
+	if f.Delta > 0 {
+		return f.Delta
+	}
+	return DefaultColorDelta
 }
 
 // MetaWrapper ...
@@ -22,10 +55,34 @@ type MetaWrapper interface {
 	BatchSave(entries []*Entry) error
 	GetMeta(id string) (*Entry, error)
 	GetHash(hash string) (*HashEntry, error)
+	Similar(phash string, distance, limit int) ([]*SimilarItem, error)
 	GetMapping(id string) (*mapItem, error)
 	Delete(id string) error
 	MapTags(id string, tags string) error
 	UnmapTags(id string, tags string) error
+	GetSource(uri string) (*Source, error)
+	SaveSource(s *Source) error
+	ListSources(id string) ([]*Source, error)
+	ListGarbage(before time.Time, limit int) ([]*Garbage, error)
+	RemoveGarbage(id string) error
+	ListStored(after string, limit int) ([]*mapItem, error)
+	FindMapped(ids []string) (mapped map[string]string, prepared map[string]bool, err error)
+	MarkBroken(id string) error
+	ListScrub(before time.Time, after string, limit int) ([]*Entry, error)
+	SaveScrub(id string, status int, msg string) error
+	ListScrubbed(status, limit, offset int) ([]*ScrubItem, int, error)
+	ListTrash(limit, offset int) ([]*TrashItem, int, error)
+	Restore(id string, app AppID) error
+	PurgeTrash(before time.Time) (int64, error)
+	Batch(app AppID, ops []BatchOp, atomic bool) ([]*BatchResult, error)
+	ListTags(prefix string, limit, offset int) ([]*Tag, int, error)
+	RenameTag(from, to string) (int, error)
+}
+
+// SimilarItem entry with distance of perceptual hash
+type SimilarItem struct {
+	*Entry
+	Distance int `json:"distance"`
 }
 
 type rowScanner interface {
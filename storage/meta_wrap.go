@@ -14,6 +14,7 @@ import (
 	"github.com/go-imsto/imagi"
 	"github.com/go-imsto/imid"
 	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/imagio"
 	cdb "github.com/go-imsto/imsto/storage/types"
 )
 
@@ -47,7 +48,7 @@ func newMetaWrap(roof string) *MetaWrap {
 
 var (
 	metaWrappers   = make(map[string]MetaWrapper)
-	metaColumns    = "id, path, name, meta, hashes, ids, size, sev, tags, exif, app_id, author, created, roof"
+	metaColumns    = "id, path, name, meta, hashes, ids, size, sev, tags, exif, app_id, author, created, roof, extra"
 	sortableFields = []string{"id", "created"}
 	ErrDbError     = errors.New("database error")
 )
@@ -57,15 +58,21 @@ const (
 )
 
 func InitMetaTables() {
+	if err := CreateMetaTables(config.Current()); err != nil {
+		logger().Fatalw("create table of meta_? fail", "err", err)
+	}
+}
+
+// CreateMetaTables 创建 c 中 roof 的 meta 表，用于重新载入前检查
+func CreateMetaTables(c *config.Config) error {
 	db := getDb()
-	roofs := config.Current.Engines
-	logger().Infow("checking or create tables of metas", "roofs", len(roofs))
-	for k := range roofs {
-		_, err := db.Exec(fmt.Sprintf(metaCreateTmpl, k))
-		if err != nil {
-			logger().Fatalw("create table of meta_? fail", "roof", k, "err", err)
+	logger().Infow("checking or create tables of metas", "roofs", len(c.Engines))
+	for k := range c.Engines {
+		if _, err := db.Exec(fmt.Sprintf(metaCreateTmpl, k)); err != nil {
+			return fmt.Errorf("create table meta_%s: %w", k, err)
 		}
 	}
+	return nil
 }
 
 // NewMetaWrapper ...
@@ -106,13 +113,12 @@ func buildWhere(filter MetaFilter) (where string, args []interface{}) {
 	argc := 0
 	args = make([]interface{}, 0, maxArgs)
 
-	var qtags, _ = cdb.NewQarrayText(filter.Tags)
-
-	if len(qtags) > 0 {
-		log.Printf("qtags len: %d", len(qtags))
-		where = fmt.Sprintf("%s AND tags @> $%d", where, argc+1)
-		args = append(args, qtags)
-		argc++
+	if tq, _ := filter.TagQuery(); len(tq) > 0 {
+		log.Printf("tags: %s", tq)
+		w, a := tq.Where("tags", argc)
+		where = where + " AND " + w
+		args = append(args, a...)
+		argc += len(a)
 	}
 
 	var author = filter.Author
@@ -131,6 +137,15 @@ func buildWhere(filter MetaFilter) (where string, args []interface{}) {
 		argc++
 	}
 
+	if lab, ok := filter.ColorLab(); ok {
+		log.Printf("color: %s, lab: %v", filter.Color, lab)
+		where = fmt.Sprintf("%s AND EXISTS (SELECT 1 FROM jsonb_array_elements(extra->'labs') c"+
+			" WHERE sqrt(power((c->>0)::float - $%d, 2) + power((c->>1)::float - $%d, 2) + power((c->>2)::float - $%d, 2)) <= $%d)",
+			where, argc+1, argc+2, argc+3, argc+4)
+		args = append(args, lab[0], lab[1], lab[2], filter.ColorDelta())
+		argc += 4
+	}
+
 	return
 }
 
@@ -222,9 +237,9 @@ func _bindRow(rs rowScanner) (*Entry, error) {
 	e := Entry{}
 	var id, roof string
 	var meta image.Attr
-	// "id, path, name, meta, hashes, ids, size, sev, exif, app_id, author, created, roof"
+	// "id, path, name, meta, hashes, ids, size, sev, exif, app_id, author, created, roof, extra"
 	err := rs.Scan(&id, &e.Path, &e.Name, &meta, &e.Hashes, &e.IDs, &e.Size,
-		&e.sev, &e.Tags, &e.exif, &e.AppId, &e.Author, &e.Created, &roof)
+		&e.sev, &e.Tags, &e.exif, &e.AppId, &e.Author, &e.Created, &roof, &e.Extra)
 	if err != nil {
 		logger().Infow("bind fail", "err", err)
 		err = ErrDbError
@@ -293,10 +308,10 @@ func (mw *MetaWrap) Ready(entry *Entry) error {
 		}
 		logger().Infow("check prepared with hash not exist", "hash", entry.GetHash(), "err", err)
 
-		_, err = tx.Exec(`INSERT INTO meta__prepared (id, roof, path, name, size, meta, hashes, ids, app_id, author, tags)
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`, entry.Id, mw.tableSuffix, entry.Path,
+		_, err = tx.Exec(`INSERT INTO meta__prepared (id, roof, path, name, size, meta, hashes, ids, app_id, author, tags, extra)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`, entry.Id, mw.tableSuffix, entry.Path,
 			entry.Name, entry.Size, entry.Meta, entry.Hashes, entry.IDs,
-			entry.AppId, entry.Author, entry.Tags)
+			entry.AppId, entry.Author, entry.Tags, entry.extra())
 		if err != nil {
 			logger().Warnw("save prepared fail", "entry", entry, "err", err)
 		} else {
@@ -331,6 +346,9 @@ func (mw *MetaWrap) Save(entry *Entry, isUpdate bool) error {
 			if err == nil {
 				a, _ := r.RowsAffected()
 				logger().Infow("entry updated", "id", entry.Id, "ra", a)
+				if a == 0 {
+					err = fmt.Errorf("%w: %s", ErrEntryNotFound, entry.Id)
+				}
 			} else {
 				logger().Warnw("save fail", "err", err)
 			}
@@ -338,10 +356,10 @@ func (mw *MetaWrap) Save(entry *Entry, isUpdate bool) error {
 		}
 	} else {
 		qs = func(tx *sql.Tx) (err error) {
-			query := "SELECT entry_save($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);"
+			query := "SELECT entry_save($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);"
 			err = tx.QueryRow(query, mw.tableSuffix,
 				entry.Id, entry.Path, entry.Name, entry.Size, entry.Meta, entry.sev, entry.Hashes, entry.IDs,
-				entry.AppId, entry.Author).Scan(&entry.ret)
+				entry.AppId, entry.Author, entry.Tags, entry.extra()).Scan(&entry.ret)
 			if err == nil {
 				log.Printf("entry save ret: %v\n", entry.ret)
 			}
@@ -355,7 +373,7 @@ func (mw *MetaWrap) Save(entry *Entry, isUpdate bool) error {
 func (mw *MetaWrap) BatchSave(entries []*Entry) error {
 	qs := func(tx *sql.Tx) error {
 
-		sql := "SELECT entry_save($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);"
+		sql := "SELECT entry_save($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);"
 		st, err := tx.Prepare(sql)
 		if err != nil {
 			return err
@@ -363,7 +381,7 @@ func (mw *MetaWrap) BatchSave(entries []*Entry) error {
 		for _, entry := range entries {
 			err := st.QueryRow(mw.tableSuffix,
 				entry.Id, entry.Path, entry.Name, entry.Size, entry.Meta, entry.sev, entry.Hashes, entry.IDs,
-				entry.AppId, entry.Author).Scan(&entry.ret)
+				entry.AppId, entry.Author, entry.Tags, entry.extra()).Scan(&entry.ret)
 			if err != nil {
 				log.Printf("batchSave %s %s error: %s", entry.Id, entry.Path, err)
 				return err
@@ -392,13 +410,68 @@ func (mw *MetaWrap) GetHash(hash string) (he *HashEntry, err error) {
 	return
 }
 
+const (
+	phashDistance = "length(replace((('x' || (extra->>'phash'))::bit(64) # ('x' || $1)::bit(64))::text, '0', ''))"
+
+	// phashBands 按 phash_bands 的索引查找，每段 8 位，距离小于 8 时必有一段相同
+	phashBands = 8
+	// MaxSimilarDistance 能用索引查找的最大距离
+	MaxSimilarDistance = phashBands - 1
+)
+
+// distScanner scan a tailing distance column after metaColumns
+type distScanner struct {
+	rs   rowScanner
+	dist *int
+}
+
+func (s distScanner) Scan(dest ...interface{}) error {
+	return s.rs.Scan(append(dest, s.dist)...)
+}
+
+// Similar search entries by hamming distance of perceptual hash
+func (mw *MetaWrap) Similar(phash string, distance, limit int) (a []*SimilarItem, err error) {
+	if _, err = imagio.ParseHash(phash); err != nil {
+		return
+	}
+	if distance < 0 || distance > MaxSimilarDistance {
+		return nil, fmt.Errorf("%w: %d out of 0-%d", ErrInvalidDistance, distance, MaxSimilarDistance)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	db := mw.getDb()
+	from := mw.table() + " WHERE status = 0 AND extra ? 'phash' AND phash_bands(extra->>'phash') && phash_bands($1)"
+	str := "SELECT " + metaColumns + ", distance FROM (SELECT *, " + phashDistance + " AS distance FROM " +
+		from + ") t WHERE distance <= $2 ORDER BY distance, created LIMIT $3"
+	var r *sql.Rows
+	r, err = db.Query(str, phash, distance, limit)
+	if err != nil {
+		logger().Warnw("query similar fail", "table", mw.table(), "err", err)
+		err = ErrDbError
+		return
+	}
+	defer r.Close()
+
+	for r.Next() {
+		item := new(SimilarItem)
+		item.Entry, err = _bindRow(distScanner{rs: r, dist: &item.Distance})
+		if err != nil {
+			return
+		}
+		a = append(a, item)
+	}
+	err = r.Err()
+	return
+}
+
 func (mw *MetaWrap) GetMapping(id string) (*mapItem, error) {
 	db := mw.getDb()
-	sql := "SELECT name, path, size, sev, status, created, roofs FROM " + tableMap(id) + " WHERE id = $1 LIMIT 1"
+	sql := "SELECT name, path, size, sev, status, created, roofs, home FROM " + tableMap(id) + " WHERE id = $1 LIMIT 1"
 	row := db.QueryRow(sql, id)
 	iID, _ := imid.ParseID(id)
 	var e = mapItem{ID: iID}
-	err := row.Scan(&e.Name, &e.Path, &e.Size, &e.sev, &e.Status, &e.Created, &e.Roofs)
+	err := row.Scan(&e.Name, &e.Path, &e.Size, &e.sev, &e.Status, &e.Created, &e.Roofs, &e.Home)
 	if err != nil {
 		logger().Infow("GetMapping fail", "roof", mw.roof, "id", id, "err", err)
 		return nil, err
@@ -421,16 +494,19 @@ func (mw *MetaWrap) Delete(id string) error {
 
 func (mw *MetaWrap) MapTags(id string, tags string) error {
 
-	var qtags, err = cdb.NewQarrayText(tags)
-	if err != nil {
-		return err
+	qtags := cleanTags(strings.Split(strings.Trim(tags, "{}"), ","))
+	if len(qtags) == 0 {
+		return ErrEmptyTags
 	}
 	qs := func(tx *sql.Tx) (err error) {
 		var ret int
-		sql := "SELECT tag_map($1, $2, $3);"
+		sql := "SELECT tag_map($1, $2, VARIADIC $3::text[]);"
 		err = tx.QueryRow(sql, mw.tableSuffix, id, qtags).Scan(&ret)
 		if err == nil {
 			log.Printf("entry [%s]%v mapping tags '%s' result %v", mw.tableSuffix, id, tags, ret)
+			if ret == -2 {
+				err = fmt.Errorf("%w: %s", ErrEntryNotFound, id)
+			}
 		}
 		return
 	}
@@ -439,16 +515,19 @@ func (mw *MetaWrap) MapTags(id string, tags string) error {
 
 func (mw *MetaWrap) UnmapTags(id string, tags string) error {
 
-	var qtags, err = cdb.NewQarrayText(tags)
-	if err != nil {
-		return err
+	qtags := cleanTags(strings.Split(strings.Trim(tags, "{}"), ","))
+	if len(qtags) == 0 {
+		return ErrEmptyTags
 	}
 	qs := func(tx *sql.Tx) (err error) {
 		var ret int
-		sql := "SELECT tag_unmap($1, $2, $3);"
+		sql := "SELECT tag_unmap($1, $2, VARIADIC $3::text[]);"
 		err = tx.QueryRow(sql, mw.tableSuffix, id, qtags).Scan(&ret)
 		if err == nil {
 			log.Printf("entry [%s]%v unmap tags '%s' result %v", mw.tableSuffix, id, tags, ret)
+			if ret == -2 {
+				err = fmt.Errorf("%w: %s", ErrEntryNotFound, id)
+			}
 		}
 		return
 	}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-imsto/imid"
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/backend"
+	cdb "github.com/go-imsto/imsto/storage/types"
+)
+
+// kinds of Finding
+const (
+	FindOrphan   = "orphan"   // blob without mapping
+	FindPending  = "pending"  // blob of a prepared entry, not set done
+	FindUnknown  = "unknown"  // blob with a key not made by imsto
+	FindDangling = "dangling" // mapping without blob
+)
+
+// fixes of Finding
+const (
+	FixRepushed = "repushed"
+	FixDeleted  = "deleted"
+	FixMarked   = "marked" // mapping status 2
+)
+
+const reconcilePage = 1000
+
+// ReconcileOption 修复的方式，都为 false 时只报告
+type ReconcileOption struct {
+	Repush        bool          // push dangling ones again from cache
+	DeleteOrphans bool          // delete orphan blobs
+	MarkBroken    bool          // mark dangling ones broken, which can not be repushed
+	MinAge        time.Duration // skip blobs newer than it, they may be in uploading
+}
+
+// Finding 引擎与记录不一致的一项
+type Finding struct {
+	Kind  string `json:"kind"`
+	Key   string `json:"key"`
+	ID    string `json:"id,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Size  uint32 `json:"size,omitempty"`
+	Fixed string `json:"fixed,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReconcileReport 一次核对的汇总
+type ReconcileReport struct {
+	Roof     string `json:"roof"`
+	Blobs    int    `json:"blobs"`
+	Records  int    `json:"records"`
+	Skipped  int    `json:"skipped"` // newer than MinAge
+	Orphans  int    `json:"orphans"`
+	Pending  int    `json:"pending"`
+	Unknown  int    `json:"unknown"`
+	Dangling int    `json:"dangling"`
+	Fixed    int    `json:"fixed"`
+	Failed   int    `json:"failed"`
+}
+
+// ListStored 文件存放在本 roof 引擎中的有效映射，按 id 分页
+func (mw *MetaWrap) ListStored(after string, limit int) (a []*mapItem, err error) {
+	var rows *sql.Rows
+	rows, err = mw.getDb().Query(`SELECT id, name, path, size, sev, status FROM map_template
+	WHERE status = 0 AND (home = $1 OR (home = '' AND roofs[1] = $1)) AND id > $2
+	ORDER BY id LIMIT $3`, mw.roof, after, limit)
+	if err != nil {
+		logger().Warnw("list stored fail", "roof", mw.roof, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := new(mapItem)
+		if err = rows.Scan(&e.ID, &e.Name, &e.Path, &e.Size, &e.sev, &e.Status); err != nil {
+			return
+		}
+		a = append(a, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// FindMapped 返回 ids 中有映射的 (id: path) 和预存未完成的
+func (mw *MetaWrap) FindMapped(ids []string) (mapped map[string]string, prepared map[string]bool, err error) {
+	db := mw.getDb()
+	mapped = make(map[string]string)
+	prepared = make(map[string]bool)
+	var rows *sql.Rows
+	if rows, err = db.Query("SELECT id, path FROM map_template WHERE id = ANY($1)", StringArray(ids)); err != nil {
+		return
+	}
+	for rows.Next() {
+		var id, p string
+		if err = rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			return
+		}
+		mapped[id] = p
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if rows, err = db.Query("SELECT id FROM meta__prepared WHERE id = ANY($1)", StringArray(ids)); err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		prepared[id] = true
+	}
+	err = rows.Err()
+	return
+}
+
+// MarkBroken 文件丢失的映射标记为 2
+func (mw *MetaWrap) MarkBroken(id string) error {
+	return mw.withTxQuery(func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE "+tableMap(id)+" SET status = 2 WHERE id = $1 AND status = 0", id)
+		return err
+	})
+}
+
+// blobID 从引擎的 key 取出 id 和文件名，raw 为原始文件
+func blobID(cat, key string) (id, name string, raw, ok bool) {
+	rest := strings.TrimPrefix(key, cat+"/")
+	if strings.HasPrefix(rest, CatRaw+"/") {
+		raw = true
+		rest = rest[len(CatRaw)+1:]
+	}
+	name = backend.Path2ID(rest)
+	if strings.Contains(name, "/") {
+		return
+	}
+	id = strings.TrimSuffix(name, path.Ext(name))
+	if _, err := imid.ParseID(id); err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+// Reconcile 核对 roof 的引擎文件与映射记录，按 opt 修复
+func Reconcile(ctx context.Context, roof string, opt ReconcileOption, out func(*Finding)) (*ReconcileReport, error) {
+	if config.GetEngine(roof) == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRoof, roof)
+	}
+	em, err := backend.FarmEngine(roof)
+	if err != nil {
+		return nil, err
+	}
+	mw := NewMetaWrapper(roof)
+	rep := &ReconcileReport{Roof: roof}
+	emit := func(f *Finding, err error) {
+		switch {
+		case err != nil:
+			f.Error = err.Error()
+			rep.Failed++
+		case f.Fixed != "":
+			rep.Fixed++
+		}
+		if out != nil {
+			out(f)
+		}
+	}
+
+	// blobs in the engine
+	cat := getItemCat(roof)
+	keys := make(map[string]bool)
+	marker := ""
+	for ctx.Err() == nil {
+		items, err := em.List(backend.ListSpec{Prefix: cat + "/", Marker: marker, Limit: reconcilePage})
+		if err != nil {
+			return rep, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		marker = items[len(items)-1].Key
+		if err = reconcileBlobs(em, mw, cat, items, keys, opt, rep, emit); err != nil {
+			return rep, err
+		}
+		if len(items) < reconcilePage {
+			break
+		}
+	}
+
+	// mappings of files in the engine
+	after := ""
+	for ctx.Err() == nil {
+		a, err := mw.ListStored(after, reconcilePage)
+		if err != nil {
+			return rep, err
+		}
+		if len(a) == 0 {
+			break
+		}
+		after = a[len(a)-1].ID.String()
+		for _, item := range a {
+			rep.Records++
+			k := item.key(roof)
+			if keys[k.Path()] {
+				continue
+			}
+			if ok, err := em.Exists(k); err == nil && ok {
+				continue // of another cat
+			}
+			rep.Dangling++
+			f := &Finding{Kind: FindDangling, Key: k.Path(), ID: item.ID.String(), Path: item.Path, Size: item.Size}
+			emit(f, fixDangling(em, mw, item, k, opt, f))
+		}
+	}
+	logger().Infow("reconcile done", "report", rep)
+	return rep, ctx.Err()
+}
+
+func reconcileBlobs(em backend.Wagoner, mw MetaWrapper, cat string, items []backend.ListItem, keys map[string]bool,
+	opt ReconcileOption, rep *ReconcileReport, emit func(*Finding, error)) error {
+	var ids []string
+	for _, it := range items {
+		keys[it.Key] = true
+		if id, _, _, ok := blobID(cat, it.Key); ok {
+			ids = append(ids, id)
+		}
+	}
+	mapped, prepared, err := mw.FindMapped(ids)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, it := range items {
+		rep.Blobs++
+		if it.LastModified != nil && now.Sub(*it.LastModified) < opt.MinAge {
+			rep.Skipped++
+			continue
+		}
+		id, name, raw, ok := blobID(cat, it.Key)
+		f := &Finding{Key: it.Key, ID: id, Path: name, Size: it.Size}
+		switch p, has := mapped[id]; {
+		case !ok:
+			f.Kind = FindUnknown
+			rep.Unknown++
+		case prepared[id]:
+			f.Kind = FindPending
+			rep.Pending++
+		case has && (raw || p == name):
+			continue
+		default:
+			f.Kind = FindOrphan
+			rep.Orphans++
+			if opt.DeleteOrphans {
+				k := backend.Key{ID: name, Cat: cat}
+				if raw {
+					k.Cat = cat + "/" + CatRaw
+				}
+				if err = em.Delete(k); err != nil {
+					emit(f, err)
+					continue
+				}
+				f.Fixed = FixDeleted
+			}
+		}
+		emit(f, nil)
+	}
+	return nil
+}
+
+// fixDangling 缓存中有同样大小的原图时重新上传，否则标记为损坏
+func fixDangling(em backend.Wagoner, mw MetaWrapper, item *mapItem, k backend.Key, opt ReconcileOption, f *Finding) error {
+	if opt.Repush {
+		name := path.Join(config.Current().CacheRoot, "thumb", "orig", storedPath(item.Path))
+		if data, err := os.ReadFile(name); err == nil && uint32(len(data)) == item.Size {
+			if _, err = em.Put(k, data, cdb.Meta{"name": item.Name, "size": len(data)}); err != nil {
+				return err
+			}
+			f.Fixed = FixRepushed
+			return nil
+		}
+	}
+	if opt.MarkBroken {
+		if err := mw.MarkBroken(item.ID.String()); err != nil {
+			return err
+		}
+		f.Fixed = FixMarked
+	}
+	return nil
+}
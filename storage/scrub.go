@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/backend"
+	"github.com/go-imsto/imsto/storage/hash"
+)
+
+// status of scrub
+const (
+	ScrubOK = iota
+	ScrubCorrupted
+	ScrubMissing
+)
+
+// DefaultScrubLimit 一次后台校验每个 roof 最多的条目数
+const DefaultScrubLimit = 1000
+
+const scrubPage = 100
+
+var scrubStatus = []string{"ok", "corrupted", "missing"}
+
+// ScrubStatus 状态的名称
+func ScrubStatus(status int) string {
+	if status >= 0 && status < len(scrubStatus) {
+		return scrubStatus[status]
+	}
+	return ""
+}
+
+// ParseScrubStatus 名称转为状态，bad 为 corrupted 和 missing，返回 -1
+func ParseScrubStatus(s string) (int, error) {
+	if s == "" || s == "bad" {
+		return -1, nil
+	}
+	for i, name := range scrubStatus {
+		if name == s {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid scrub status %q", s)
+}
+
+// ScrubOption ...
+type ScrubOption struct {
+	Age   time.Duration // check entries verified before it again
+	Rate  int64         // bytes read per second, 0 is no limit
+	Limit int           // max entries, 0 is no limit
+}
+
+// ScrubResult 一个条目的校验结果
+type ScrubResult struct {
+	ID       IID    `json:"id"`
+	Path     string `json:"path"`
+	Key      string `json:"key,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ScrubReport 一次校验的汇总
+type ScrubReport struct {
+	Roof      string `json:"roof"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	OK        int    `json:"ok"`
+	Corrupted int    `json:"corrupted"`
+	Missing   int    `json:"missing"`
+	Skipped   int    `json:"skipped"` // without hash
+	Failed    int    `json:"failed"`  // not checked, the engine fails
+}
+
+// ScrubItem 校验有问题的条目
+type ScrubItem struct {
+	*Entry
+	Scrub      string    `json:"scrub"`
+	ScrubError string    `json:"scrub_error,omitempty"`
+	Checked    time.Time `json:"checked"`
+}
+
+// ListScrub id 在 after 之后，从未校验或校验早于 before 的条目
+func (mw *MetaWrap) ListScrub(before time.Time, after string, limit int) (a []*Entry, err error) {
+	var rows *sql.Rows
+	rows, err = mw.getDb().Query("SELECT "+metaColumns+" FROM "+mw.table()+` m
+	WHERE id > $2 AND NOT EXISTS (SELECT 1 FROM scrub s WHERE s.roof = $1 AND s.item_id = m.id AND s.checked >= $3)
+	ORDER BY id LIMIT $4`, mw.roof, after, before, limit)
+	if err != nil {
+		logger().Warnw("list scrub fail", "roof", mw.roof, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e *Entry
+		if e, err = _bindRow(rows); err != nil {
+			return
+		}
+		a = append(a, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// SaveScrub 记录校验时间和状态
+func (mw *MetaWrap) SaveScrub(id string, status int, msg string) error {
+	return mw.withTxQuery(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO scrub (roof, item_id, status, error) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (roof, item_id) DO UPDATE SET status = EXCLUDED.status, error = EXCLUDED.error,
+		checked = CURRENT_TIMESTAMP`, mw.roof, id, status, msg)
+		return err
+	})
+}
+
+// ListScrubbed 按状态列出校验过的条目，status 为 -1 时列出所有有问题的
+func (mw *MetaWrap) ListScrubbed(status, limit, offset int) (a []*ScrubItem, total int, err error) {
+	where := " WHERE s.roof = $1 AND s.status = $2"
+	if status < 0 {
+		where = " WHERE s.roof = $1 AND s.status > $2"
+		status = ScrubOK
+	}
+	from := " FROM " + mw.table() + " m JOIN scrub s ON s.item_id = m.id" + where
+	db := mw.getDb()
+	if err = db.QueryRow("SELECT COUNT(*)"+from, mw.roof, status).Scan(&total); err != nil {
+		logger().Warnw("count scrubbed fail", "roof", mw.roof, "err", err)
+		return
+	}
+	var rows *sql.Rows
+	rows, err = db.Query("SELECT "+metaColumns+", scrub_status, scrub_error, checked FROM (SELECT m.*"+
+		", s.status AS scrub_status, s.error AS scrub_error, s.checked"+from+") t ORDER BY checked DESC LIMIT $3 OFFSET $4",
+		mw.roof, status, limit, offset)
+	if err != nil {
+		logger().Warnw("list scrubbed fail", "roof", mw.roof, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item := new(ScrubItem)
+		var st int
+		item.Entry, err = _bindRow(tailScanner{rs: rows, tail: []interface{}{&st, &item.ScrubError, &item.Checked}})
+		if err != nil {
+			return
+		}
+		item.Scrub = ScrubStatus(st)
+		a = append(a, item)
+	}
+	err = rows.Err()
+	return
+}
+
+// tailScanner scan tailing columns after metaColumns
+type tailScanner struct {
+	rs   rowScanner
+	tail []interface{}
+}
+
+func (s tailScanner) Scan(dest ...interface{}) error {
+	return s.rs.Scan(append(dest, s.tail...)...)
+}
+
+// throttle 限制读取速度
+type throttle struct {
+	ctx   context.Context
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (t *throttle) wait(n int) error {
+	t.n += int64(n)
+	if t.rate <= 0 {
+		return nil
+	}
+	if d := time.Duration(t.n*int64(time.Second)/t.rate) - time.Since(t.start); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		}
+	}
+	return nil
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if werr := r.t.wait(n); werr != nil && err == nil {
+		err = werr
+	}
+	return
+}
+
+// expectedHash 存储的文件的哈希，内容被转换过时是 hash2
+func expectedHash(e *Entry) string {
+	for _, k := range []string{"hash2", "hash"} {
+		if v, ok := e.Hashes.Get(k); ok {
+			if s, ok := v.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Scrub 重新读取 roof 中条目的文件并计算哈希，记录校验时间和结果
+func Scrub(ctx context.Context, roof string, opt ScrubOption, out func(*ScrubResult)) (*ScrubReport, error) {
+	if config.GetEngine(roof) == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRoof, roof)
+	}
+	mw := NewMetaWrapper(roof)
+	rep := &ScrubReport{Roof: roof}
+	th := &throttle{ctx: ctx, rate: opt.Rate, start: time.Now()}
+	engines := make(map[string]backend.Wagoner)
+	before := time.Now().Add(-opt.Age)
+	defer func() {
+		rep.Bytes = th.n
+		logger().Infow("scrub done", "report", rep)
+	}()
+	after := ""
+	for ctx.Err() == nil {
+		a, err := mw.ListScrub(before, after, scrubPage)
+		if err != nil {
+			return rep, err
+		}
+		if len(a) == 0 {
+			break
+		}
+		after = a[len(a)-1].Id.String()
+		for _, e := range a {
+			if ctx.Err() != nil || (opt.Limit > 0 && rep.Entries >= opt.Limit) {
+				return rep, ctx.Err()
+			}
+			rep.Entries++
+			res := scrubEntry(mw, e, engines, th)
+			switch {
+			case res.Status == "" && res.Expected == "":
+				rep.Skipped++
+			case res.Status == "":
+				rep.Failed++
+			case res.Status == ScrubStatus(ScrubOK):
+				rep.OK++
+			case res.Status == ScrubStatus(ScrubCorrupted):
+				rep.Corrupted++
+			case res.Status == ScrubStatus(ScrubMissing):
+				rep.Missing++
+			}
+			if res.Status != "" && res.Status != ScrubStatus(ScrubOK) {
+				logger().Warnw("scrub found", "roof", roof, "result", res)
+			}
+			if out != nil {
+				out(res)
+			}
+		}
+	}
+	return rep, ctx.Err()
+}
+
+// scrubEntry 校验一个条目，引擎出错时不记录状态，下次再校验
+func scrubEntry(mw MetaWrapper, e *Entry, engines map[string]backend.Wagoner, th *throttle) *ScrubResult {
+	res := &ScrubResult{ID: e.Id, Path: e.Path, Size: int64(e.Size), Expected: expectedHash(e)}
+	if res.Expected == "" {
+		return res
+	}
+	item, err := mw.GetMapping(e.Id.String())
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	roof := item.roof()
+	em, ok := engines[roof]
+	if !ok {
+		if em, err = backend.FarmEngine(roof); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		engines[roof] = em
+	}
+	k := item.key(roof)
+	res.Key = k.Path()
+
+	status := ScrubOK
+	var msg string
+	res.Actual, err = sumBlob(em, k, th)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		status, msg = ScrubMissing, err.Error()
+	case err != nil:
+		res.Error = err.Error()
+		return res
+	case !strings.EqualFold(res.Actual, res.Expected):
+		status, msg = ScrubCorrupted, fmt.Sprintf("%s: %s", hash.ErrMismatch, res.Actual)
+	}
+	if err = mw.SaveScrub(e.Id.String(), status, msg); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Status = ScrubStatus(status)
+	return res
+}
+
+// sumBlob 流式读取计算哈希，引擎不支持时整个读入
+func sumBlob(em backend.Wagoner, k backend.Key, th *throttle) (string, error) {
+	var r io.Reader
+	if op, ok := em.(backend.Opener); ok {
+		rc, err := op.Open(k)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		r = rc
+	} else {
+		data, err := em.Get(k)
+		if err != nil {
+			if ok, xerr := em.Exists(k); xerr == nil && !ok {
+				return "", fmt.Errorf("%w: %s", fs.ErrNotExist, k.Path())
+			}
+			return "", err
+		}
+		r = bytes.NewReader(data)
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, &throttledReader{r: r, t: th}); err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Source 远程抓取的来源，同一个 URI 只抓取一次
+type Source struct {
+	URI          string    `json:"uri"`
+	ItemID       IID       `json:"-"`
+	Referer      string    `json:"referer,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Created      time.Time `json:"created"`
+	Checked      time.Time `json:"checked"` // last fetched or revalidated
+}
+
+const sourceColumns = "uri, item_id, referer, etag, last_modified, created, checked"
+
+func bindSource(rs rowScanner) (*Source, error) {
+	s := new(Source)
+	err := rs.Scan(&s.URI, &s.ItemID, &s.Referer, &s.ETag, &s.LastModified, &s.Created, &s.Checked)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetSource ...
+func (mw *MetaWrap) GetSource(uri string) (*Source, error) {
+	row := mw.getDb().QueryRow("SELECT "+sourceColumns+" FROM source WHERE roof = $1 AND uri = $2", mw.roof, uri)
+	s, err := bindSource(row)
+	if err != nil && err != sql.ErrNoRows {
+		logger().Warnw("get source fail", "roof", mw.roof, "uri", uri, "err", err)
+	}
+	return s, err
+}
+
+// SaveSource 新增或更新来源，并刷新 checked
+func (mw *MetaWrap) SaveSource(s *Source) error {
+	return mw.withTxQuery(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO source (roof, uri, item_id, referer, etag, last_modified)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (roof, uri) DO UPDATE SET item_id = EXCLUDED.item_id, referer = EXCLUDED.referer,
+		etag = EXCLUDED.etag, last_modified = EXCLUDED.last_modified, checked = CURRENT_TIMESTAMP`,
+			mw.roof, s.URI, s.ItemID, s.Referer, s.ETag, s.LastModified)
+		if err != nil {
+			logger().Warnw("save source fail", "roof", mw.roof, "uri", s.URI, "err", err)
+		}
+		return err
+	})
+}
+
+// ListSources 一个条目的所有来源，最近的在前
+func (mw *MetaWrap) ListSources(id string) (a []*Source, err error) {
+	var rows *sql.Rows
+	rows, err = mw.getDb().Query("SELECT "+sourceColumns+" FROM source WHERE roof = $1 AND item_id = $2 ORDER BY checked DESC",
+		mw.roof, id)
+	if err != nil {
+		logger().Warnw("list sources fail", "roof", mw.roof, "id", id, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s *Source
+		if s, err = bindSource(rows); err != nil {
+			return
+		}
+		a = append(a, s)
+	}
+	err = rows.Err()
+	return
+}
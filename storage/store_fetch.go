@@ -2,21 +2,17 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
-	"time"
-)
+	"strings"
+	"sync"
 
-const (
-	userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36"
-)
-
-var (
-	dfthc = &http.Client{
-		Timeout: 25 * time.Second,
-	}
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/fetcher"
 )
 
 // FetchInput ...
@@ -26,45 +22,123 @@ type FetchInput struct {
 	Referer string
 	AppID   int
 	UserID  int
+	Tags    StringArray
+
+	Revalidate bool // send a conditional request for a known URI
 }
 
-// Fetch ...
-func Fetch(in FetchInput) (entry *Entry, err error) {
-	var req *http.Request
-	req, err = http.NewRequest(http.MethodGet, in.URI, nil)
-	if err != nil {
-		return
+// fetchers 每个 roof 复用一个 fetcher 和它的连接，配置重新载入后重建
+var fetchers struct {
+	sync.Mutex
+	cfg *config.Config
+	m   map[string]*fetcher.Client
+}
+
+// getFetcher 返回当前配置下 roof 的 fetcher
+func getFetcher(roof string) (*fetcher.Client, error) {
+	if config.GetEngine(roof) == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRoof, roof)
 	}
-	name := path.Base(req.URL.Path)
-	if len(in.Referer) > 0 {
-		req.Header.Set("Referer", in.Referer)
+	c := config.Current()
+	fetchers.Lock()
+	defer fetchers.Unlock()
+	if fetchers.cfg != c {
+		for _, fc := range fetchers.m {
+			fc.CloseIdleConnections()
+		}
+		fetchers.cfg, fetchers.m = c, make(map[string]*fetcher.Client)
+	}
+	if fc, ok := fetchers.m[roof]; ok {
+		return fc, nil
+	}
+	fc, err := newFetcher(c, roof)
+	if err != nil {
+		return nil, err
 	}
-	req.Header.Set("User-Agent", userAgent)
+	fetchers.m[roof] = fc
+	return fc, nil
+}
 
-	logger().Infow("fetching", "in", in, "name", name)
+// newFetcher 按配置和 roof 的限制创建 fetcher
+func newFetcher(c *config.Config, roof string) (*fetcher.Client, error) {
+	sec := config.GetSection(roof)
+	var types []string
+	for _, f := range sec.Formats {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "jpg" {
+			f = "jpeg"
+		}
+		types = append(types, "image/"+f)
+	}
+	return fetcher.New(
+		fetcher.WithAllow(c.FetchAllow...),
+		fetcher.WithDeny(c.FetchDeny...),
+		fetcher.WithRedirects(c.FetchRedirects),
+		fetcher.WithTimeout(c.FetchTimeout),
+		fetcher.WithUserAgent(c.FetchUserAgent),
+		fetcher.WithMaxSize(sec.UploadLimit()),
+		fetcher.WithContentTypes(types...),
+	)
+}
 
-	var res *http.Response
-	res, err = dfthc.Do(req)
+// Fetch 抓取远程图片，已抓取过的 URI 直接返回已有条目，Revalidate 时用条件请求检查是否有变化
+func Fetch(in FetchInput) (entry *Entry, err error) {
+	u, err := url.Parse(strings.TrimSpace(in.URI))
 	if err != nil {
-		logger().Warnw("fetch fail", "err", err)
 		return
 	}
+	u.Fragment = ""
+	uri := u.String()
+	name := path.Base(u.Path)
 
-	defer res.Body.Close()
-	logger().Infow("fetched", "code", res.StatusCode, "len", res.ContentLength, "content-type", res.Header.Get("Content-Type"))
+	mw := NewMetaWrapper(in.Roof)
+	src, _ := mw.GetSource(uri)
+	if src != nil {
+		if entry, err = mw.GetMeta(src.ItemID.String()); err != nil {
+			logger().Infow("entry of source is gone", "uri", uri, "id", src.ItemID, "err", err)
+			src, entry, err = nil, nil, nil
+		} else if !in.Revalidate {
+			logger().Infow("fetch hit source", "uri", uri, "id", entry.Id)
+			return
+		}
+	}
 
-	var data []byte
-	data, err = ioutil.ReadAll(res.Body)
+	fc, err := getFetcher(in.Roof)
 	if err != nil {
 		return
 	}
-	// Check the response
-	if res.StatusCode != 200 {
-		err = fmt.Errorf("status code %d: %s", res.StatusCode, res.Status)
+	header := http.Header{}
+	if len(in.Referer) > 0 {
+		header.Set("Referer", in.Referer)
+	}
+	if src != nil {
+		if src.ETag != "" {
+			header.Set("If-None-Match", src.ETag)
+		}
+		if src.LastModified != "" {
+			header.Set("If-Modified-Since", src.LastModified)
+		}
+	}
+
+	logger().Infow("fetching", "in", in, "name", name)
+	res, err := fc.Get(context.Background(), uri, header)
+	if err != nil {
+		logger().Warnw("fetch fail", "err", err)
+		if errors.Is(err, fetcher.ErrTooLarge) {
+			err = fmt.Errorf("%w: %s", ErrTooLarge, err)
+		} else if errors.Is(err, fetcher.ErrContentType) {
+			err = fmt.Errorf("%w: %s", ErrUnsupportedFormat, err)
+		}
+		return nil, err
+	}
+	if res.NotModified && src != nil {
+		logger().Infow("fetch not modified", "uri", uri, "id", entry.Id)
+		err = mw.SaveSource(src)
 		return
 	}
+	logger().Infow("fetched", "url", res.URL, "len", len(res.Data), "content-type", res.ContentType)
 
-	entry, err = NewEntryReader(bytes.NewReader(data), name)
+	entry, err = PrepareReader(in.Roof, bytes.NewReader(res.Data), name)
 	if err != nil {
 		return
 	}
@@ -72,7 +146,23 @@ func Fetch(in FetchInput) (entry *Entry, err error) {
 		entry.AppId = AppID(in.AppID)
 		entry.Author = Author(in.UserID)
 	}
-	err = <-entry.Store(in.Roof)
+	if len(in.Tags) > 0 {
+		entry.Tags = in.Tags
+	}
+	if err = <-entry.Store(in.Roof); err != nil {
+		return
+	}
+
+	ns := &Source{
+		URI:          uri,
+		ItemID:       entry.Id,
+		Referer:      in.Referer,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	if se := mw.SaveSource(ns); se != nil {
+		logger().Warnw("save source fail", "uri", uri, "id", entry.Id, "err", se)
+	}
 
 	return
 }
@@ -1,15 +1,18 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/go-imsto/imid"
 	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage/backend"
 	"github.com/go-imsto/imsto/storage/imagio"
 	"github.com/go-imsto/imsto/storage/thumbs"
 	cdb "github.com/go-imsto/imsto/storage/types"
@@ -21,6 +24,7 @@ const (
 	CatView  = "show"
 	CatStore = "stores"
 	CatThumb = "thumb"
+	CatRaw   = "raw" // untouched upload, under cat of roof
 )
 
 // errors
@@ -30,6 +34,20 @@ var (
 	ErrEmptyID     = errors.New("empty id")
 	ErrZeroSize    = errors.New("zero size")
 	ErrInvalidRoof = errors.New("empty roof")
+
+	ErrNearDuplicate   = errors.New("near duplicate")
+	ErrInvalidDistance = errors.New("invalid distance")
+
+	ErrTooLarge          = errors.New("too large")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+
+	ErrInvalidHash  = errors.New("invalid hash")
+	ErrHashNotFound = errors.New("hash not found")
+
+	ErrNotInTrash  = errors.New("not in trash")
+	ErrNotOwner    = errors.New("entry of other app")
+	ErrPurged      = errors.New("purged")
+	ErrEntryExists = errors.New("entry exists")
 )
 
 type File = thumbs.File
@@ -49,18 +67,39 @@ func storedPath(r string) string {
 
 // LoadPath ...
 func LoadPath(u string, walk thumbs.WalkFunc) error {
+	p, err := imagio.ParseFromPath(u)
+	if err != nil {
+		return NewHttpError(400, err.Error())
+	}
+	if p.IsRaw {
+		return NewHttpError(403, "raw file need authorization")
+	}
+	var (
+		entry *mapItem
+		roof  string
+	)
+	// 缓存命中时不查映射
 	th, err := thumbs.New(
-		config.Current.CacheRoot,
-		thumbs.WithLoader(func(p thumbs.Item) error {
+		config.Current().CacheRoot,
+		thumbs.WithSizesOf(func(_ thumbs.Item) (imagio.Sizes, error) {
 			mw := NewMetaWrapper(commonRoof)
-			entry, err := mw.GetMapping(p.GetID())
+			var err error
+			entry, err = mw.GetMapping(p.ID.String())
 			if err != nil {
-				logger().Infow("get mapping fail", "name", p.GetName(), "err", err)
-				return NewHttpError(404, err.Error())
+				logger().Infow("get mapping fail", "name", p.Name, "err", err)
+				return nil, NewHttpError(404, err.Error())
+			}
+			if entry.Status != 0 {
+				return nil, NewHttpError(404, "entry is deleted")
 			}
-			roof := entry.roof()
-			var data []byte
-			data, err = entry.pullWith(roof)
+			roof = entry.roof()
+			return config.GetSection(roof).SupportSizes, nil
+		}),
+		thumbs.WithLoader(func(p thumbs.Item) error {
+			if entry == nil { // 缓存在检查后被清除了
+				return NewHttpError(503, "cache is purged, retry later")
+			}
+			data, err := entry.pullWith(roof)
 			if err != nil {
 				return NewHttpError(500, err.Error())
 			}
@@ -73,18 +112,132 @@ func LoadPath(u string, walk thumbs.WalkFunc) error {
 	return th.Thumbnail(u)
 }
 
+type rawFile struct {
+	*bytes.Reader
+	name     string
+	modified time.Time
+}
+
+func (f *rawFile) Name() string {
+	return f.name
+}
+
+func (f *rawFile) Modified() time.Time {
+	return f.modified
+}
+
+func getRawCat(roof string) string {
+	return getItemCat(roof) + "/" + CatRaw
+}
+
+// LoadRaw 读取保存的原始文件，调用者需要先验证授权，只有条目所属的 app 可以读取
+func LoadRaw(u string, app AppID, walk thumbs.WalkFunc) error {
+	p, err := imagio.ParseFromPath(u)
+	if err != nil || !p.IsRaw {
+		return NewHttpError(400, fmt.Sprintf("invalid raw path: %s", u))
+	}
+	mw := NewMetaWrapper(commonRoof)
+	entry, err := mw.GetMapping(p.ID.String())
+	if err != nil {
+		return NewHttpError(404, err.Error())
+	}
+	if entry.Status != 0 {
+		return NewHttpError(404, "entry is deleted")
+	}
+	if !entry.ownedBy(app) {
+		return NewHttpError(403, "raw file of other app")
+	}
+	roof := entry.roof()
+	em, err := backend.FarmEngine(roof)
+	if err != nil {
+		return NewHttpError(500, err.Error())
+	}
+	data, err := em.Get(backend.Key{ID: p.Name, Cat: getRawCat(roof)})
+	if err != nil {
+		logger().Infow("get raw fail", "roof", roof, "name", p.Name, "err", err)
+		return NewHttpError(404, "raw file not found")
+	}
+	f := &rawFile{Reader: bytes.NewReader(data), name: p.Name}
+	if entry.Created != nil {
+		f.modified = *entry.Created
+	}
+	walk(f)
+	return nil
+}
+
+// CheckHeader 解码前只读取文件头，检查格式和声明的尺寸
+func CheckHeader(roof string, rs io.ReadSeeker) error {
+	h, err := imagio.Probe(rs)
+	if _, se := rs.Seek(0, io.SeekStart); se != nil {
+		return se
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, err)
+	}
+	sec := config.GetSection(roof)
+	if !sec.AllowFormat(h.Format) {
+		return fmt.Errorf("%w: %s is not allowed in %s", ErrUnsupportedFormat, h.Format, roof)
+	}
+	if sec.MaxPixels > 0 && h.Pixels() > sec.MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels, max is %d", ErrTooLarge, h.Width, h.Height, sec.MaxPixels)
+	}
+	if sec.Oversize != config.OversizeDownscale &&
+		(uint32(h.Width) > sec.MaxWidth || uint32(h.Height) > sec.MaxHeight) {
+		return fmt.Errorf("%w: dimension %dx%d, max is %dx%d", ErrTooLarge, h.Width, h.Height, sec.MaxWidth, sec.MaxHeight)
+	}
+	return nil
+}
+
+// ReadLimited 读取全部内容，超过 n 字节时尽快返回 ErrTooLarge
+func ReadLimited(r io.Reader, n int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, n+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > n {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, n)
+	}
+	return data, nil
+}
+
+// checkSize 检查可以 Seek 的内容大小
+func checkSize(roof string, rs io.ReadSeeker) error {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if limit := config.GetSection(roof).UploadLimit(); size > limit {
+		return fmt.Errorf("%w: size %d, max is %d", ErrTooLarge, size, limit)
+	}
+	return nil
+}
+
 // PrepareReader ...
-func PrepareReader(r io.ReadSeeker, name string) (entry *Entry, err error) {
+func PrepareReader(roof string, r io.ReadSeeker, name string) (entry *Entry, err error) {
+	if err = checkSize(roof, r); err != nil {
+		logger().Infow("check size fail", "roof", roof, "name", name, "err", err)
+		return
+	}
+	if err = CheckHeader(roof, r); err != nil {
+		logger().Infow("check header fail", "roof", roof, "name", name, "err", err)
+		return
+	}
 
 	entry, err = NewEntryReader(r, name)
 	if err != nil {
 		return
 	}
+	if config.GetSection(roof).KeepRaw {
+		err = entry.readRaw(r)
+	}
 	return
 }
 
 // PrepareFile ...
-func PrepareFile(file, name string) (entry *Entry, err error) {
+func PrepareFile(roof, file, name string) (entry *Entry, err error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
@@ -104,7 +257,7 @@ func PrepareFile(file, name string) (entry *Entry, err error) {
 		name = path.Base(file)
 	}
 
-	return PrepareReader(f, name)
+	return PrepareReader(roof, f, name)
 }
 
 // ParseTags ...
@@ -130,13 +283,28 @@ func Delete(roof, id string) error {
 	if err != nil {
 		return err
 	}
+	purgeCached(eid.String())
 	return nil
 }
 
+// Dump 把原图保存到本地文件
+func Dump(roof, id, name string) error {
+	mw := NewMetaWrapper(roof)
+	item, err := mw.GetMapping(id)
+	if err != nil {
+		return err
+	}
+	data, err := item.pullWith(item.roof())
+	if err != nil {
+		return err
+	}
+	return utils.SaveFile(name, data)
+}
+
 // GetURI ...
-func GetURI(suffix string) string {
+func GetURI(roof, suffix string) string {
 	spath := path.Join("/", CatView, suffix)
-	stageHost := config.Current.StageHost
+	stageHost := config.GetSection(roof).Host
 	if stageHost == "" {
 		return spath
 	}
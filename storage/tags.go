@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTagLen 标签最长的字符数
+const MaxTagLen = 40
+
+// ErrInvalidTag ...
+var ErrInvalidTag = errors.New("invalid tag")
+
+// Tag 标签和 roof 中有它的条目数
+type Tag struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// cleanTags 转为小写，去掉空的和重复的
+func cleanTags(tags []string) StringArray {
+	a := StringArray{}
+	for _, s := range tags {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" && !slices.Contains(a, s) {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// ListTags 本 roof 中以 prefix 开头的标签，条目多的在前
+func (mw *MetaWrap) ListTags(prefix string, limit, offset int) (a []*Tag, total int, err error) {
+	where := " FROM tag WHERE roof = $1 AND item_count > 0"
+	args := []interface{}{mw.roof}
+	if prefix = strings.ToLower(strings.TrimSpace(prefix)); prefix != "" {
+		where += " AND label LIKE $2"
+		args = append(args, strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)+"%")
+	}
+	db := mw.getDb()
+	if err = db.QueryRow("SELECT COUNT(*)"+where, args...).Scan(&total); err != nil {
+		logger().Warnw("count tags fail", "roof", mw.roof, "err", err)
+		return
+	}
+	var rows *sql.Rows
+	rows, err = db.Query(fmt.Sprintf("SELECT label, item_count%s ORDER BY item_count DESC, label LIMIT %d OFFSET %d",
+		where, limit, offset), args...)
+	if err != nil {
+		logger().Warnw("list tags fail", "roof", mw.roof, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := new(Tag)
+		if err = rows.Scan(&t.Label, &t.Count); err != nil {
+			return
+		}
+		a = append(a, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// RenameTag 本 roof 所有条目的标签 from 改为 to，已有 to 时合并，返回改变的条目数
+func (mw *MetaWrap) RenameTag(from, to string) (n int, err error) {
+	from = strings.ToLower(strings.TrimSpace(from))
+	to = strings.ToLower(strings.TrimSpace(to))
+	if from == "" || to == "" || strings.Contains(to, ",") || utf8.RuneCountInString(to) > MaxTagLen {
+		return 0, fmt.Errorf("%w: %q to %q", ErrInvalidTag, from, to)
+	}
+	err = mw.withTxQuery(func(tx *sql.Tx) error {
+		return tx.QueryRow("SELECT tag_rename($1, $2, $3)", mw.tableSuffix, from, to).Scan(&n)
+	})
+	if err == nil {
+		logger().Infow("rename tag", "roof", mw.roof, "from", from, "to", to, "entries", n)
+	}
+	return
+}
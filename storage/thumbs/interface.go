@@ -3,6 +3,8 @@ package thumbs
 import (
 	"io"
 	"time"
+
+	"github.com/go-imsto/imsto/storage/imagio"
 )
 
 // Item item for load and read
@@ -27,6 +29,9 @@ type File interface {
 // LoadFunc load by key and save it into a file
 type LoadFunc func(Item) error
 
+// SizesFunc 没有缓存时调用，返回 item 允许的尺寸，空的不限制
+type SizesFunc func(Item) (imagio.Sizes, error)
+
 // WalkFunc ..
 type WalkFunc func(f File)
 
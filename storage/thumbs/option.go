@@ -25,6 +25,13 @@ func WithSizes(ss ...uint) func(*thumber) {
 	}
 }
 
+// WithSizesOf 按条目检查尺寸，只在缓存未命中时调用
+func WithSizesOf(fn SizesFunc) func(*thumber) {
+	return func(s *thumber) {
+		s.sizesOf = fn
+	}
+}
+
 func WithWatermark(filename string) func(*thumber) {
 	return func(s *thumber) {
 		s.watermark = filename
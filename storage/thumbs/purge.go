@@ -0,0 +1,13 @@
+package thumbs
+
+import (
+	"path/filepath"
+
+	"github.com/go-imsto/imsto/storage/imagio"
+)
+
+// Derivatives 缓存中一个 id 的原图和所有缩略图 (含各种扩展名和锁文件)
+func Derivatives(root, id string) ([]string, error) {
+	pattern := filepath.Join(root, CatThumb, "*", imagio.StoredPath(id)+".*")
+	return filepath.Glob(pattern)
+}
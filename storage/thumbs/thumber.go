@@ -46,6 +46,7 @@ type thumber struct {
 	loader       LoadFunc
 	walker       WalkFunc
 	okSizes      imagio.Sizes
+	sizesOf      SizesFunc
 }
 
 func (s *thumber) Thumbnail(u string) error {
@@ -83,6 +84,16 @@ func (s *thumber) Thumbnail(u string) error {
 		oi.dst = path.Join(oi.root, dstPath)
 	}
 
+	if s.sizesOf != nil && !oi.cached() {
+		ss, err := s.sizesOf(oi)
+		if err != nil {
+			return err
+		}
+		if !oi.isOrig && len(ss) > 0 && !p.ValidSizes(ss...) {
+			return NewCodeError(400, fmt.Sprintf("unsupported size: %s", p.SizeOp[1:]))
+		}
+	}
+
 	err = utils.ReadyDir(oi.origFile)
 	if err != nil {
 		logger().Infow("ready dir fail", "err", err)
@@ -173,6 +184,12 @@ func (o *outItem) Walk(c WalkFunc) error {
 	return nil
 }
 
+// cached 已经生成过，不用再加载
+func (o *outItem) cached() bool {
+	fi, err := os.Stat(o.dst)
+	return err == nil && fi.Size() > 0 && o.p.Mop == ""
+}
+
 func (s *thumber) prepare(o *outItem) (err error) {
 	o.lock.Lock()
 	defer o.lock.Unlock()
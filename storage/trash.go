@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-imsto/imid"
+	"github.com/go-imsto/imsto/config"
+)
+
+// TrashItem 回收站中的条目，过期后由 gc 清除
+type TrashItem struct {
+	*Entry
+	Deleted time.Time `json:"deleted"`
+	Expires time.Time `json:"expires"`
+}
+
+// ListTrash 本 roof 删除的条目，最近删除的在前
+func (mw *MetaWrap) ListTrash(limit, offset int) (a []*TrashItem, total int, err error) {
+	db := mw.getDb()
+	if err = db.QueryRow("SELECT COUNT(*) FROM meta__deleted WHERE roof = $1", mw.roof).Scan(&total); err != nil {
+		logger().Warnw("count trash fail", "roof", mw.roof, "err", err)
+		return
+	}
+	var rows *sql.Rows
+	rows, err = db.Query("SELECT "+metaColumns+", deleted FROM meta__deleted WHERE roof = $1"+
+		" ORDER BY deleted DESC, id LIMIT $2 OFFSET $3", mw.roof, limit, offset)
+	if err != nil {
+		logger().Warnw("list trash fail", "roof", mw.roof, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item := new(TrashItem)
+		item.Entry, err = _bindRow(tailScanner{rs: rows, tail: []interface{}{&item.Deleted}})
+		if err != nil {
+			return
+		}
+		item.Expires = item.Deleted.Add(config.Current().TrashRetention)
+		a = append(a, item)
+	}
+	err = rows.Err()
+	return
+}
+
+// Restore 从回收站恢复到本 roof，并重新启用映射，只能恢复 app 自己的、没有过期的条目
+func (mw *MetaWrap) Restore(id string, app AppID) error {
+	return mw.withTxQuery(func(tx *sql.Tx) error {
+		var owner AppID
+		var deleted time.Time
+		err := tx.QueryRow("SELECT app_id, deleted FROM meta__deleted WHERE roof = $1 AND id = $2 FOR UPDATE",
+			mw.roof, id).Scan(&owner, &deleted)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", ErrNotInTrash, id)
+		}
+		if err != nil {
+			return err
+		}
+		if owner != app {
+			return fmt.Errorf("%w: %s", ErrNotOwner, id)
+		}
+		if time.Since(deleted) > config.Current().TrashRetention {
+			return fmt.Errorf("%w: %s expired", ErrPurged, id)
+		}
+		var ret int
+		if err := tx.QueryRow("SELECT entry_restore($1, $2)", mw.tableSuffix, id).Scan(&ret); err != nil {
+			return err
+		}
+		logger().Infow("restore entry", "roof", mw.roof, "id", id, "ret", ret)
+		switch ret {
+		case -1:
+			return fmt.Errorf("%w: %s", ErrNotInTrash, id)
+		case -2:
+			return fmt.Errorf("%w: %s", ErrPurged, id)
+		case -3:
+			return fmt.Errorf("%w: %s", ErrEntryExists, id)
+		}
+		return nil
+	})
+}
+
+// PurgeTrash 清除删除早于 before 的条目，文件待 gc 清除的保留
+func (mw *MetaWrap) PurgeTrash(before time.Time) (n int64, err error) {
+	err = mw.withTxQuery(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM meta__deleted d WHERE deleted < $1
+		AND NOT EXISTS (SELECT 1 FROM map_template m WHERE m.status = 1
+		 AND (m.id = d.id OR m.id::text = ANY(d.ids::text[])))`, before)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return
+}
+
+// Restore 恢复 roof 中 app 删除的条目
+func Restore(roof, id string, app AppID) (*Entry, error) {
+	if roof == "" {
+		return nil, ErrEmptyRoof
+	}
+	if id == "" {
+		return nil, ErrEmptyID
+	}
+	eid, err := imid.ParseID(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotInTrash, err)
+	}
+	mw := NewMetaWrapper(roof)
+	if err = mw.Restore(eid.String(), app); err != nil {
+		return nil, err
+	}
+	return mw.GetMeta(eid.String())
+}
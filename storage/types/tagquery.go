@@ -0,0 +1,127 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTagQuery invalid tag query
+var ErrTagQuery = errors.New("invalid tag query")
+
+// TagGroup 组内的标签都要有，None 中的都不能有
+type TagGroup struct {
+	All  Qarray
+	None Qarray
+}
+
+// TagQuery 标签查询，组之间为或
+//
+// 语法: `cat,dog | bird -nsfw` 即 (cat 且 dog) 或 (bird 且非 nsfw)，
+// 组以 | 分隔，组内的标签以逗号或空格分隔，- 开头的为非
+type TagQuery []TagGroup
+
+// ParseTagQuery 解析标签查询，标签转为小写，空的返回 nil
+func ParseTagQuery(s string) (TagQuery, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = strings.Trim(s, "{}")
+	}
+	if s == "" {
+		return nil, nil
+	}
+	var q TagQuery
+	for _, part := range strings.Split(s, "|") {
+		var g TagGroup
+		terms := strings.FieldsFunc(part, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		for _, raw := range terms {
+			term := strings.ToLower(raw)
+			not := strings.HasPrefix(term, "-")
+			if not {
+				term = term[1:]
+			}
+			if term == "" || strings.HasPrefix(term, "-") {
+				return nil, fmt.Errorf("%w: bad term %q in %q", ErrTagQuery, raw, s)
+			}
+			if not {
+				g.None = appendTag(g.None, term)
+			} else {
+				g.All = appendTag(g.All, term)
+			}
+		}
+		if len(g.All) == 0 && len(g.None) == 0 {
+			return nil, fmt.Errorf("%w: empty group in %q", ErrTagQuery, s)
+		}
+		q = append(q, g)
+	}
+	return q, nil
+}
+
+func appendTag(q Qarray, s string) Qarray {
+	if q.Contains(s) {
+		return q
+	}
+	return append(q, s)
+}
+
+// single 只有一个要有的标签
+func (g TagGroup) single() bool {
+	return len(g.All) == 1 && len(g.None) == 0
+}
+
+// Where 转为 column 的条件，参数从 $argc+1 开始，
+// 相邻的单个标签的组合并为 &&
+func (q TagQuery) Where(column string, argc int) (where string, args []interface{}) {
+	param := func(v Qarray) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", argc+len(args))
+	}
+	var ors []string
+	for i := 0; i < len(q); i++ {
+		g := q[i]
+		if g.single() && i+1 < len(q) && q[i+1].single() {
+			tags := Qarray{}
+			for ; i < len(q) && q[i].single(); i++ {
+				tags = appendTag(tags, q[i].All[0].(string))
+			}
+			i--
+			ors = append(ors, column+" && "+param(tags))
+			continue
+		}
+		var ands []string
+		if len(g.All) > 0 {
+			ands = append(ands, column+" @> "+param(g.All))
+		}
+		switch len(g.None) {
+		case 0:
+		case 1:
+			ands = append(ands, "NOT "+column+" @> "+param(g.None))
+		default:
+			ands = append(ands, "NOT "+column+" && "+param(g.None))
+		}
+		if len(ands) > 1 && len(q) > 1 {
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		} else {
+			ors = append(ors, strings.Join(ands, " AND "))
+		}
+	}
+	if len(ors) == 1 {
+		return ors[0], args
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// String 规范的写法
+func (q TagQuery) String() string {
+	groups := make([]string, len(q))
+	for i, g := range q {
+		terms := g.All.ToStringSlice()
+		for _, s := range g.None.ToStringSlice() {
+			terms = append(terms, "-"+s)
+		}
+		groups[i] = strings.Join(terms, ",")
+	}
+	return strings.Join(groups, " | ")
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseTagQuery(t *testing.T) {
+	cases := []struct {
+		s     string
+		str   string
+		where string
+		args  string
+	}{
+		{"", "", "", "[]"},
+		{"cat", "cat", "tags @> $3", `[{"cat"}]`},
+		{"cat,Dog", "cat,dog", "tags @> $3", `[{"cat","dog"}]`},
+		{"{cat,dog}", "cat,dog", "tags @> $3", `[{"cat","dog"}]`},
+		{"cat dog cat", "cat,dog", "tags @> $3", `[{"cat","dog"}]`},
+		{"cat | dog|bird", "cat | dog | bird", "tags && $3", `[{"cat","dog","bird"}]`},
+		{"-nsfw", "-nsfw", "NOT tags @> $3", `[{"nsfw"}]`},
+		{"cat -nsfw -gore", "cat,-nsfw,-gore", "tags @> $3 AND NOT tags && $4", `[{"cat"} {"nsfw","gore"}]`},
+		{"cat,dog | bird -nsfw", "cat,dog | bird,-nsfw",
+			"(tags @> $3 OR (tags @> $4 AND NOT tags @> $5))", `[{"cat","dog"} {"bird"} {"nsfw"}]`},
+		{"a | b | c,d | e", "a | b | c,d | e", "(tags && $3 OR tags @> $4 OR tags @> $5)", `[{"a","b"} {"c","d"} {"e"}]`},
+	}
+	for _, c := range cases {
+		q, err := ParseTagQuery(c.s)
+		if err != nil {
+			t.Errorf("parse %q: %s", c.s, err)
+			continue
+		}
+		if s := q.String(); s != c.str {
+			t.Errorf("parse %q: got %q, want %q", c.s, s, c.str)
+		}
+		if len(q) == 0 {
+			continue
+		}
+		where, args := q.Where("tags", 2)
+		if where != c.where {
+			t.Errorf("where of %q: got %q, want %q", c.s, where, c.where)
+		}
+		vals := make([]interface{}, len(args))
+		for i, a := range args {
+			vals[i], _ = a.(Qarray).Value()
+		}
+		if s := fmt.Sprintf("%s", vals); s != c.args {
+			t.Errorf("args of %q: got %s, want %s", c.s, s, c.args)
+		}
+	}
+}
+
+func TestParseTagQueryInvalid(t *testing.T) {
+	for _, s := range []string{"cat |", "| cat", "cat || dog", "-", "cat --dog", ", |"} {
+		if _, err := ParseTagQuery(s); !errors.Is(err, ErrTagQuery) {
+			t.Errorf("parse %q: got %v, want ErrTagQuery", s, err)
+		}
+	}
+}
@@ -138,10 +138,42 @@ func TestEntry(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
+	item, err := mw.GetMapping(IID.String())
+	assert.NoError(t, err)
+	assert.True(t, item.ownedBy(entry.AppId))
+	assert.False(t, item.ownedBy(entry.AppId+1))
+
 	err = Delete(roof, IID.String())
 	assert.NoError(t, err)
 }
 
+// TestLinkWithoutEntry 映射还在但没有任何 roof 的条目时不能静默成功
+func TestLinkWithoutEntry(t *testing.T) {
+	roof := "demo"
+	buf, err := base64.StdEncoding.DecodeString(jpegData)
+	assert.NoError(t, err)
+	entry, err := NewEntryReader(bytes.NewReader(buf), "test.jpg")
+	assert.NoError(t, err)
+	assert.NoError(t, <-entry.Store(roof))
+
+	mw := NewMetaWrapper(roof)
+	_, err = getDb().Exec("DELETE FROM meta_"+roof+" WHERE id = $1", entry.Id.String())
+	assert.NoError(t, err)
+
+	e := &Entry{AppId: 1, Author: 2}
+	err = e.linkExist(roof, mw, &HashEntry{ID: entry.Id, Path: entry.Path})
+	assert.ErrorIs(t, err, ErrHashNotFound)
+
+	_, err = LookupHash(roof, entry.h)
+	assert.ErrorIs(t, err, ErrHashNotFound)
+
+	_, err = Claim(roof, entry.h, 1, 2, "")
+	assert.ErrorIs(t, err, ErrHashNotFound)
+
+	err = mw.Save(&Entry{Id: entry.Id}, true)
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+}
+
 const (
 	tSalt  = "abcd"
 	tValue = "test"
@@ -1,6 +1,8 @@
 package web
 
 import (
+	"encoding/json"
+	"mime"
 	"net/http"
 
 	"github.com/go-playground/form"
@@ -10,7 +12,7 @@ var (
 	formDecoder = form.NewDecoder()
 )
 
-// Bind ...
+// Bind 解析表单，JSON 的请求体再按 json 解析
 func Bind(req *http.Request, obj interface{}) error {
 	if err := req.ParseForm(); err != nil {
 		return err
@@ -19,6 +21,9 @@ func Bind(req *http.Request, obj interface{}) error {
 	if err := formDecoder.Decode(obj, req.Form); err != nil {
 		return err
 	}
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "application/json" {
+		return json.NewDecoder(req.Body).Decode(obj)
+	}
 	return nil
 }
 
@@ -30,6 +35,18 @@ type uploadSchema struct {
 	Tags   string `form:"tags"`
 }
 
+type fetchSchema struct {
+	APIKey  string   `form:"api_key"`
+	Token   string   `form:"token"`
+	Roof    string   `form:"roof"`
+	User    int      `form:"user"`
+	URLs    []string `form:"url"`
+	Referer string   `form:"referer"`
+	Tags    string   `form:"tags"`
+
+	Revalidate bool `form:"revalidate"`
+}
+
 type tokenSchema struct {
 	APIKey string `form:"api_key"`
 	Roof   string `form:"roof"`
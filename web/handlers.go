@@ -1,17 +1,25 @@
 package web
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bmizerany/pat"
 
 	"github.com/go-imsto/imid"
 	"github.com/go-imsto/imsto/config"
 	"github.com/go-imsto/imsto/storage"
+	"github.com/go-imsto/imsto/storage/fetcher"
+	"github.com/go-imsto/imsto/storage/hash"
+	"github.com/go-imsto/imsto/storage/imagio"
+	"github.com/go-imsto/imsto/web/tus"
 )
 
 // Handler ...
@@ -19,16 +27,38 @@ func Handler() http.Handler {
 	mux := pat.New()
 	mux.Get("/imsto/roofs", http.HandlerFunc(roofsHandler))
 
-	mux.Post("/imsto/ticket", CheckAPIKey(http.HandlerFunc(ticketHandlerPost)))
+	mux.Post("/imsto/ticket", limitBody(CheckAPIKey(http.HandlerFunc(ticketHandlerPost))))
 	mux.Get("/imsto/ticket", CheckAPIKey(http.HandlerFunc(ticketHandlerGet)))
 
-	mux.Post("/imsto/token", CheckAPIKey(http.HandlerFunc(tokenHandler)))
-
-	mux.Post("/imsto/:roof", CheckAPIKey(secure(storedHandler)))
+	mux.Post("/imsto/token", limitBody(CheckAPIKey(http.HandlerFunc(tokenHandler))))
+
+	mux.Post("/imsto/:roof/fetch", limitBody(CheckAPIKey(secure(fetchHandler))))
+	mux.Options("/imsto/:roof/upload", tusServe(func(h *tus.Handler, w http.ResponseWriter, r *http.Request, roof, _ string) {
+		h.ServeOptions(w, r, roof)
+	}))
+	mux.Post("/imsto/:roof/upload", limitBody(CheckAPIKey(secure(tusServe(func(h *tus.Handler, w http.ResponseWriter, r *http.Request, roof, _ string) {
+		h.ServeCreate(w, r, roof)
+	})))))
+	mux.Head("/imsto/:roof/upload/:uid", CheckAPIKey(tusServe((*tus.Handler).ServeHead)))
+	mux.Patch("/imsto/:roof/upload/:uid", limitUpload(CheckAPIKey(secure(tusServe((*tus.Handler).ServePatch)))))
+	mux.Get("/imsto/:roof/upload/:uid", CheckAPIKey(tusServe((*tus.Handler).ServeResult)))
+	mux.Put("/imsto/:roof/blob", limitUpload(CheckAPIKey(secure(blobHandler))))
+	mux.Post("/imsto/:roof/hash/:hash/claim", limitBody(CheckAPIKey(secure(claimHandler))))
+	mux.Get("/imsto/:roof/hash/:hash", CheckAPIKey(http.HandlerFunc(hashHandler)))
+	mux.Post("/imsto/:roof/batch", limitBody(CheckAPIKey(secure(batchHandler))))
+	mux.Post("/imsto/:roof/tags/rename", limitBody(CheckAPIKey(secure(tagRenameHandler))))
+	mux.Post("/imsto/:roof/:id/tags", limitBody(CheckAPIKey(secure(entryTagsHandler))))
+	mux.Del("/imsto/:roof/:id/tags", limitBody(CheckAPIKey(secure(entryTagsHandler))))
+	mux.Post("/imsto/:roof/:id/restore", limitBody(CheckAPIKey(secure(restoreHandler))))
+	mux.Post("/imsto/:roof", limitUpload(CheckAPIKey(secure(storedHandler))))
 	mux.Del("/imsto/:roof/:id", CheckAPIKey(secure(deleteHandler)))
 	mux.Get("/imsto/:roof/id", http.HandlerFunc(GetOrHeadHandler))
+	mux.Get("/imsto/:roof/similar", http.HandlerFunc(similarHandler))
 	mux.Get("/imsto/:roof/metas/count", http.HandlerFunc(countHandler))
 	mux.Get("/imsto/:roof/metas", http.HandlerFunc(browseHandler))
+	mux.Get("/imsto/:roof/tags", http.HandlerFunc(tagsHandler))
+	mux.Get("/imsto/:roof/scrub", CheckAPIKey(http.HandlerFunc(scrubHandler)))
+	mux.Get("/imsto/:roof/trash", CheckAPIKey(http.HandlerFunc(trashHandler)))
 	// mux.Post("/imsto/:roof/token", http.HandlerFunc(tokenHandler))
 	// mux.Post("/imsto/:roof/ticket", http.HandlerFunc(ticketHandler))
 
@@ -39,6 +69,11 @@ func Handler() http.Handler {
 func StageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("X-Server", "IMSTO STAGE")
 
+	if strings.HasPrefix(r.URL.Path, "/"+storage.CatView+"/"+storage.CatRaw+"/") {
+		CheckAPIKey(http.HandlerFunc(rawHandler)).ServeHTTP(w, r)
+		return
+	}
+
 	walk := func(file storage.File) {
 		http.ServeContent(w, r, file.Name(), file.Modified(), file)
 	}
@@ -63,9 +98,32 @@ func StageHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// rawHandler 未经处理的原始文件，只给授权的调用者
+func rawHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	walk := func(file storage.File) {
+		http.ServeContent(w, r, file.Name(), file.Modified(), file)
+	}
+	app, ok := AppFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(400)
+		writeJson(w, r, "app error")
+		return
+	}
+	if err := storage.LoadRaw(r.URL.Path, app.Id, walk); err != nil {
+		logger().Infow("load raw fail", "uri", r.URL.Path, "err", err)
+		if he, ok := err.(*storage.HttpError); ok {
+			w.WriteHeader(he.Code)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		writeJSONError(w, r, err)
+	}
+}
+
 func roofsHandler(w http.ResponseWriter, r *http.Request) {
 	m := newApiMeta(true)
-	writeJSONQuiet(w, r, newApiRes(m, config.Current.Roofs))
+	writeJSONQuiet(w, r, newApiRes(m, config.Current().Roofs))
 }
 
 func browseHandler(w http.ResponseWriter, r *http.Request) {
@@ -112,7 +170,12 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		sort[sort_name] = o
 	}
 
-	filter := storage.MetaFilter{Tags: r.FormValue("tags")}
+	filter, err := parseFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
 
 	mw := storage.NewMetaWrapper(roof)
 	t, err := mw.Count(filter)
@@ -139,8 +202,8 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 
 	m["total"] = t
 
-	m["stageHost"] = config.Current.StageHost
-	m["urlPrefix"] = getURL(r, "") + "/"
+	m["stageHost"] = config.GetSection(roof).Host
+	m["urlPrefix"] = getURL(roof, "") + "/"
 	m["version"] = config.Version
 	writeJSONQuiet(w, r, newApiRes(m, a))
 }
@@ -148,7 +211,12 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 func countHandler(w http.ResponseWriter, r *http.Request) {
 	roof := r.FormValue("roof")
 
-	filter := storage.MetaFilter{Tags: r.FormValue("tags")}
+	filter, err := parseFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
 
 	mw := storage.NewMetaWrapper(roof)
 	t, err := mw.Count(filter)
@@ -165,6 +233,181 @@ func countHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSONQuiet(w, r, newApiRes(m, nil))
 }
 
+// scrubHandler 校验有问题的条目，用于重新复制
+func scrubHandler(w http.ResponseWriter, r *http.Request) {
+	roof := r.URL.Query().Get(":roof")
+	status, err := storage.ParseScrubStatus(r.FormValue("status"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
+	limit, offset := parsePaging(r)
+	a, t, err := storage.NewMetaWrapper(roof).ListScrubbed(status, limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSONError(w, r, err)
+		return
+	}
+	m := newApiMeta(true)
+	m["rows"] = limit
+	m["skip"] = offset
+	m["total"] = t
+	m["stageHost"] = config.GetSection(roof).Host
+	m["urlPrefix"] = getURL(roof, "") + "/"
+	m["version"] = config.Version
+	writeJSONQuiet(w, r, newApiRes(m, a))
+}
+
+// trashHandler 回收站中的条目，过期前可以恢复
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	roof := r.URL.Query().Get(":roof")
+	limit, offset := parsePaging(r)
+	a, t, err := storage.NewMetaWrapper(roof).ListTrash(limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSONError(w, r, err)
+		return
+	}
+	m := newApiMeta(true)
+	m["rows"] = limit
+	m["skip"] = offset
+	m["total"] = t
+	m["stageHost"] = config.GetSection(roof).Host
+	m["urlPrefix"] = getURL(roof, "") + "/"
+	m["version"] = config.Version
+	writeJSONQuiet(w, r, newApiRes(m, a))
+}
+
+// tagsHandler roof 中的标签和条目数
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	roof := r.URL.Query().Get(":roof")
+	limit, offset := parsePaging(r)
+	a, t, err := storage.NewMetaWrapper(roof).ListTags(r.FormValue("prefix"), limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSONError(w, r, err)
+		return
+	}
+	m := newApiMeta(true)
+	m["rows"] = limit
+	m["skip"] = offset
+	m["total"] = t
+	writeJSONQuiet(w, r, newApiRes(m, a))
+}
+
+// entryTagsHandler 给条目添加 (POST) 或删除 (DELETE) 标签
+func entryTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if !verifyToken(w, r) {
+		return
+	}
+	roof := r.URL.Query().Get(":roof")
+	id, err := imid.ParseID(r.URL.Query().Get(":id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
+	mw := storage.NewMetaWrapper(roof)
+	if r.Method == http.MethodDelete {
+		err = mw.UnmapTags(id.String(), r.FormValue("tags"))
+	} else {
+		err = mw.MapTags(id.String(), r.FormValue("tags"))
+	}
+	var entry *storage.Entry
+	if err == nil {
+		entry, err = mw.GetMeta(id.String())
+	}
+	if err != nil {
+		w.WriteHeader(tagErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	meta := newApiMeta(true)
+	meta["stageHost"] = config.GetSection(roof).Host
+	meta["urlPrefix"] = getURL(roof, "") + "/"
+	meta["version"] = config.Version
+	writeJSONQuiet(w, r, newApiRes(meta, []*storage.Entry{entry}))
+}
+
+// tagRenameHandler roof 中所有条目的标签改名，已有新名称时合并
+func tagRenameHandler(w http.ResponseWriter, r *http.Request) {
+	if !verifyToken(w, r) {
+		return
+	}
+	n, err := storage.NewMetaWrapper(r.URL.Query().Get(":roof")).RenameTag(r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		w.WriteHeader(tagErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	meta := newApiMeta(true)
+	meta["changed"] = n
+	writeJSONQuiet(w, r, newApiRes(meta, nil))
+}
+
+// verifyToken 与上传一样校验参数中的 token，失败时已写出响应
+func verifyToken(w http.ResponseWriter, r *http.Request) bool {
+	app, appOK := AppFromContext(r.Context())
+	if !appOK {
+		w.WriteHeader(400)
+		writeJson(w, r, "app error")
+		return false
+	}
+	if _, err := app.VerifyToken(r.FormValue("token")); err != nil {
+		writeJSONError(w, r, err)
+		return false
+	}
+	return true
+}
+
+func tagErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrEmptyTags), errors.Is(err, storage.ErrInvalidTag):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrEntryNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// parsePaging read rows and skip from query
+func parsePaging(r *http.Request) (limit, offset int) {
+	limit = 20
+	if str := r.FormValue("rows"); str != "" {
+		if limit, _ = strconv.Atoi(str); limit < 1 {
+			limit = 1
+		}
+	}
+	if str := r.FormValue("skip"); str != "" {
+		if offset, _ = strconv.Atoi(str); offset < 0 {
+			offset = 0
+		}
+	}
+	return
+}
+
+// parseFilter read filter of browse and count from query
+func parseFilter(r *http.Request) (filter storage.MetaFilter, err error) {
+	filter.Tags = r.FormValue("tags")
+	if _, err = filter.TagQuery(); err != nil {
+		return
+	}
+	if str := r.FormValue("color"); str != "" {
+		if _, err = imagio.ParseHexColor(str); err != nil {
+			return
+		}
+		filter.Color = str
+	}
+	if str := r.FormValue("delta"); str != "" {
+		filter.Delta, err = strconv.ParseFloat(str, 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
 // GetOrHeadHandler ...
 func GetOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := imid.ParseID(r.URL.Query().Get(":id"))
@@ -188,40 +431,171 @@ func GetOrHeadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "HEAD" {
 		return
 	}
-	url := getURL(r, "orig/"+entry.Path)
+	url := getURL(roof, "orig/"+entry.Path)
 	log.Printf("Get entry: %v", entry.Id)
 	meta := newApiMeta(true)
 	obj := struct {
 		*storage.Entry
-		OrigURL string `json:"orig_url,omitempty"`
+		OrigURL string            `json:"orig_url,omitempty"`
+		RawURL  string            `json:"raw_url,omitempty"`
+		Sources []*storage.Source `json:"sources,omitempty"`
 	}{
 		Entry:   entry,
 		OrigURL: url,
 	}
+	if name := entry.RawName(); name != "" {
+		obj.RawURL = getURL(roof, storage.CatRaw+"/"+name)
+	}
+	obj.Sources, _ = mw.ListSources(entry.Id.String())
 	writeJSONQuiet(w, r, newApiRes(meta, obj))
 }
 
-func getURL(r *http.Request, size string) string {
-	return storage.GetURI(size)
+// hashHandler 上传前按内容哈希检查是否已存在，HEAD 只返回状态
+func hashHandler(w http.ResponseWriter, r *http.Request) {
+	roof := r.URL.Query().Get(":roof")
+	entry, err := storage.LookupHash(roof, r.URL.Query().Get(":hash"))
+	if err != nil {
+		w.WriteHeader(hashErrorStatus(err))
+		if r.Method != http.MethodHead {
+			writeJSONError(w, r, err)
+		}
+		return
+	}
+	w.Header().Set("X-Imsto-Id", entry.Id.String())
+	w.Header().Set("X-Imsto-Path", entry.Path)
+	if r.Method == http.MethodHead {
+		return
+	}
+	obj := struct {
+		*storage.Entry
+		OrigURL string `json:"orig_url,omitempty"`
+	}{
+		Entry:   entry,
+		OrigURL: getURL(roof, "orig/"+entry.Path),
+	}
+	writeJSONQuiet(w, r, newApiRes(newApiMeta(true), obj))
+}
+
+// claimHandler 不上传内容，把已有的条目关联到调用者
+func claimHandler(w http.ResponseWriter, r *http.Request) {
+	var us uploadSchema
+	if err := Bind(r, &us); err != nil {
+		w.WriteHeader(bodyErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	roof := r.URL.Query().Get(":roof")
+	app, appOK := AppFromContext(r.Context())
+	if !appOK {
+		w.WriteHeader(400)
+		writeJson(w, r, "app error")
+		return
+	}
+	if _, err := app.VerifyToken(us.Token); err != nil {
+		writeJSONError(w, r, err)
+		return
+	}
+	entry, err := storage.Claim(roof, r.URL.Query().Get(":hash"), app.Id, storage.Author(us.User), us.Tags)
+	if err != nil {
+		w.WriteHeader(hashErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	meta := newApiMeta(true)
+	meta["stageHost"] = config.GetSection(roof).Host
+	meta["urlPrefix"] = getURL(roof, "") + "/"
+	meta["version"] = config.Version
+	writeJSONQuiet(w, r, newApiRes(meta, []*storage.Entry{entry}))
+}
+
+func hashErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrInvalidHash):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrHashNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// similarHandler 按感知哈希查找近似的图片
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := imid.ParseID(r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
+	distance := config.Current().NearDupDistance
+	if str := r.FormValue("distance"); str != "" {
+		distance, err = strconv.Atoi(str)
+		if err != nil || distance < 0 || distance > storage.MaxSimilarDistance {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSONError(w, r, fmt.Errorf("%w %q, it is 0-%d", storage.ErrInvalidDistance, str, storage.MaxSimilarDistance))
+			return
+		}
+	}
+	rows, _ := strconv.Atoi(r.FormValue("rows"))
+	if rows < 1 || rows > 100 {
+		rows = 20
+	}
+
+	roof := r.URL.Query().Get(":roof")
+	mw := storage.NewMetaWrapper(roof)
+	entry, err := mw.GetMeta(id.String())
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSONError(w, r, err)
+		return
+	}
+	phash := entry.ExtraString(storage.ExtraPHash)
+	if phash == "" {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSONError(w, r, fmt.Errorf("entry %s has no perceptual hash", entry.Id))
+		return
+	}
+	a, err := mw.Similar(phash, distance, rows+1)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("ERROR: %s", err)
+		writeJSONError(w, r, err)
+		return
+	}
+	items := make([]*storage.SimilarItem, 0, len(a))
+	for _, item := range a {
+		if item.Id != entry.Id && len(items) < rows {
+			items = append(items, item)
+		}
+	}
+
+	meta := newApiMeta(true)
+	meta["phash"] = phash
+	meta["distance"] = distance
+	meta["url_prefix"] = getURL(roof, "") + "/"
+	writeJSONQuiet(w, r, newApiRes(meta, items))
+}
+
+func getURL(roof, size string) string {
+	return storage.GetURI(roof, size)
 }
 
 func storedHandler(w http.ResponseWriter, r *http.Request) {
 	var us uploadSchema
 	err := Bind(r, &us)
 	if err != nil {
-		w.WriteHeader(400)
+		w.WriteHeader(bodyErrorStatus(err))
 		writeJSONError(w, r, err)
 		return
 	}
 	if us.Roof == "" {
 		us.Roof = r.URL.Query().Get(":roof")
 	}
-	if err = r.ParseMultipartForm(DefaultMaxMemory); err != nil {
-		log.Print("multipart form parse error:", err)
-		w.WriteHeader(400)
-		writeJSONError(w, r, err)
+	if r.MultipartForm == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, http.ErrNotMultipart)
 		return
 	}
+	limit := config.GetSection(us.Roof).UploadLimit()
 	app, appOK := AppFromContext(r.Context())
 	if !appOK {
 		w.WriteHeader(400)
@@ -236,43 +610,49 @@ func storedHandler(w http.ResponseWriter, r *http.Request) {
 
 	tags, _ := storage.ParseTags(us.Tags)
 	var entries []*storage.Entry
-	for k, fhs := range r.MultipartForm.File {
-		for i, fh := range fhs {
-			entry := new(storage.Entry)
-			entry.Key = k
-			log.Printf("%d name: %s, ctype: %s", i, fh.Filename, fh.Header.Get("Content-Type"))
-			mime := fh.Header.Get("Content-Type")
-			file, fe := fh.Open()
-			if fe != nil {
-				entry.Err = fe.Error()
-				entries = append(entries, entry)
-				continue
-			}
-
-			logger().Infow("post upload", "name", fh.Filename, "mime", mime, "size", fh.Size)
-			var ee error
-			entry, ee = storage.PrepareReader(file, fh.Filename)
-			if ee != nil {
-				logger().Infow("prepare upload fail", "name", fh.Filename)
-				entry.Err = ee.Error()
-				entries = append(entries, entry)
-				continue
-			}
-			entry.AppId = app.Id
-			entry.Author = storage.Author(us.User)
-			// entry.Modified = lastModified
-			entry.Tags = tags
-			ee = <-entry.Store(us.Roof)
-			if ee != nil {
-				logger().Infow("stored fail", "i", i, "roof", us.Roof, "id", entry.Id, "err", ee)
-				entry.Err = ee.Error()
-				entries = append(entries, entry)
-				continue
-			}
-			logger().Infow("stored", "i", i, "roof", us.Roof, "id", entry.Id, "path", entry.Path)
+	var status int
+	for i, fh := range uploadFilesFromContext(r.Context()) {
+		entry := new(storage.Entry)
+		entry.Key = fh.Key
+		log.Printf("%d name: %s, ctype: %s", i, fh.Name, fh.MIME)
+		if fh.Err == nil && int64(len(fh.Data)) > limit {
+			fh.Err = fmt.Errorf("%w: size %d, max is %d", storage.ErrTooLarge, len(fh.Data), limit)
+		}
+		if fh.Err != nil {
+			entry.Name = fh.Name
+			entry.Err = fh.Err.Error()
+			entries = append(entries, entry)
+			status = errorStatus(fh.Err, http.StatusBadRequest)
+			continue
+		}
 
+		logger().Infow("post upload", "name", fh.Name, "mime", fh.MIME, "size", len(fh.Data))
+		ne, ee := storage.PrepareReader(us.Roof, bytes.NewReader(fh.Data), fh.Name)
+		if ee != nil {
+			logger().Infow("prepare upload fail", "name", fh.Name, "err", ee)
+			entry.Name = fh.Name
+			entry.Err = ee.Error()
+			entries = append(entries, entry)
+			status = errorStatus(ee, status)
+			continue
+		}
+		ne.Key = fh.Key
+		entry = ne
+		entry.AppId = app.Id
+		entry.Author = storage.Author(us.User)
+		// entry.Modified = lastModified
+		entry.Tags = tags
+		ee = <-entry.Store(us.Roof)
+		if ee != nil {
+			logger().Infow("stored fail", "i", i, "roof", us.Roof, "id", entry.Id, "err", ee)
+			entry.Err = ee.Error()
 			entries = append(entries, entry)
+			status = errorStatus(ee, status)
+			continue
 		}
+		logger().Infow("stored", "i", i, "roof", us.Roof, "id", entry.Id, "path", entry.Path)
+
+		entries = append(entries, entry)
 	}
 
 	if err != nil {
@@ -284,13 +664,172 @@ func storedHandler(w http.ResponseWriter, r *http.Request) {
 	// log.Print(entries[0].Path)
 	meta := newApiMeta(true)
 
-	meta["stageHost"] = config.Current.StageHost
-	meta["urlPrefix"] = getURL(r, "") + "/"
+	meta["stageHost"] = config.GetSection(us.Roof).Host
+	meta["urlPrefix"] = getURL(us.Roof, "") + "/"
 	meta["version"] = config.Version
 
+	if status > 0 {
+		w.WriteHeader(status)
+	}
 	writeJSONQuiet(w, r, newApiRes(meta, entries))
 }
 
+// blobHandler 请求体就是图片，参数在 X-Imsto-* 头或 query 中，可以带摘要校验
+func blobHandler(w http.ResponseWriter, r *http.Request) {
+	roof := r.URL.Query().Get(":roof")
+	param := func(name string) string {
+		if v := r.Header.Get("X-Imsto-" + name); v != "" {
+			return v
+		}
+		return r.URL.Query().Get(strings.ToLower(name))
+	}
+	app, appOK := AppFromContext(r.Context())
+	if !appOK {
+		w.WriteHeader(400)
+		writeJson(w, r, "app error")
+		return
+	}
+	if _, err := app.VerifyToken(param("Token")); err != nil {
+		writeJSONError(w, r, err)
+		return
+	}
+
+	data, err := storage.ReadLimited(r.Body, config.GetSection(roof).UploadLimit())
+	if err != nil {
+		status := bodyErrorStatus(err)
+		w.WriteHeader(errorStatus(err, status))
+		writeJSONError(w, r, err)
+		return
+	}
+	if err = hash.Verify(data, r.Header.Get("Content-MD5"), param("Hash")); err != nil {
+		logger().Infow("blob verify fail", "roof", roof, "len", len(data), "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, err)
+		return
+	}
+
+	name := param("Name")
+	entry, err := storage.PrepareReader(roof, bytes.NewReader(data), name)
+	if err == nil {
+		entry.AppId = app.Id
+		entry.Author = storage.Author(atoi(param("User")))
+		entry.Tags, _ = storage.ParseTags(param("Tags"))
+		err = <-entry.Store(roof)
+	}
+
+	meta := newApiMeta(err == nil)
+	meta["stageHost"] = config.GetSection(roof).Host
+	meta["urlPrefix"] = getURL(roof, "") + "/"
+	meta["version"] = config.Version
+	if err != nil {
+		logger().Infow("blob store fail", "roof", roof, "name", name, "err", err)
+		if entry == nil {
+			entry = &storage.Entry{Name: name}
+		}
+		entry.Err = err.Error()
+		w.WriteHeader(errorStatus(err, http.StatusBadRequest))
+	} else {
+		logger().Infow("blob stored", "roof", roof, "id", entry.Id, "path", entry.Path)
+	}
+	writeJSONQuiet(w, r, newApiRes(meta, []*storage.Entry{entry}))
+}
+
+// fetchHandler 从一个或多个 URL 导入图片，返回与上传相同的结构
+func fetchHandler(w http.ResponseWriter, r *http.Request) {
+	var fs fetchSchema
+	if err := Bind(r, &fs); err != nil {
+		w.WriteHeader(bodyErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	if fs.Roof == "" {
+		fs.Roof = r.URL.Query().Get(":roof")
+	}
+	var urls []string
+	for _, s := range fs.URLs {
+		if s = strings.TrimSpace(s); s != "" {
+			urls = append(urls, s)
+		}
+	}
+	if len(urls) == 0 || len(urls) > maxFetchURLs {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, r, fmt.Errorf("need 1 to %d url", maxFetchURLs))
+		return
+	}
+	app, appOK := AppFromContext(r.Context())
+	if !appOK {
+		w.WriteHeader(400)
+		writeJson(w, r, "app error")
+		return
+	}
+	if _, err := app.VerifyToken(fs.Token); err != nil {
+		writeJSONError(w, r, err)
+		return
+	}
+
+	tags, _ := storage.ParseTags(fs.Tags)
+	var entries []*storage.Entry
+	var status int
+	for i, uri := range urls {
+		entry, err := storage.Fetch(storage.FetchInput{
+			URI:     uri,
+			Roof:    fs.Roof,
+			Referer: fs.Referer,
+			AppID:   int(app.Id),
+			UserID:  fs.User,
+			Tags:    tags,
+
+			Revalidate: fs.Revalidate,
+		})
+		if entry == nil {
+			entry = new(storage.Entry)
+		}
+		entry.Key = uri
+		if err != nil {
+			logger().Infow("fetch fail", "i", i, "roof", fs.Roof, "uri", uri, "err", err)
+			entry.Err = err.Error()
+			status = errorStatus(err, http.StatusBadRequest)
+		} else {
+			logger().Infow("fetched", "i", i, "roof", fs.Roof, "id", entry.Id, "path", entry.Path)
+		}
+		entries = append(entries, entry)
+	}
+
+	meta := newApiMeta(true)
+	meta["stageHost"] = config.GetSection(fs.Roof).Host
+	meta["urlPrefix"] = getURL(fs.Roof, "") + "/"
+	meta["version"] = config.Version
+
+	if status > 0 {
+		w.WriteHeader(status)
+	}
+	writeJSONQuiet(w, r, newApiRes(meta, entries))
+}
+
+// bodyErrorStatus 读取请求体的错误，超出限制时为 413
+func bodyErrorStatus(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// errorStatus 入库被拒绝时的 HTTP 状态码，其他错误返回 dft
+func errorStatus(err error, dft int) int {
+	switch {
+	case errors.Is(err, storage.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, storage.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, fetcher.ErrForbidden), errors.Is(err, fetcher.ErrUnsupportedScheme):
+		return http.StatusForbidden
+	case errors.Is(err, fetcher.ErrStatus), errors.Is(err, fetcher.ErrTooManyRedirects):
+		return http.StatusBadGateway
+	}
+	return dft
+}
+
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	err := storage.Delete(r.URL.Query().Get(":roof"), r.URL.Query().Get(":id"))
 	if err != nil {
@@ -301,9 +840,91 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	meta := newApiMeta(true)
+	meta["expires"] = time.Now().Add(config.Current().TrashRetention)
 	writeJSONQuiet(w, r, newApiRes(meta, nil))
 }
 
+// restoreHandler 从回收站恢复本应用删除的条目
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !verifyToken(w, r) {
+		return
+	}
+	app, _ := AppFromContext(r.Context())
+	roof := r.URL.Query().Get(":roof")
+	entry, err := storage.Restore(roof, r.URL.Query().Get(":id"), app.Id)
+	if err != nil {
+		w.WriteHeader(restoreErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	meta := newApiMeta(true)
+	meta["stageHost"] = config.GetSection(roof).Host
+	meta["urlPrefix"] = getURL(roof, "") + "/"
+	meta["version"] = config.Version
+	writeJSONQuiet(w, r, newApiRes(meta, []*storage.Entry{entry}))
+}
+
+func restoreErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotInTrash):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrPurged):
+		return http.StatusGone
+	case errors.Is(err, storage.ErrEntryExists):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrEmptyRoof), errors.Is(err, storage.ErrEmptyID):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+type batchSchema struct {
+	Atomic bool              `json:"atomic" form:"atomic"`
+	Ops    []storage.BatchOp `json:"ops" form:"ops"`
+}
+
+// batchHandler 批量删除、修改标签和作者，每个操作有自己的结果，只能操作本应用的条目
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if !verifyToken(w, r) {
+		return
+	}
+	app, _ := AppFromContext(r.Context())
+	var bs batchSchema
+	if err := Bind(r, &bs); err != nil {
+		w.WriteHeader(bodyErrorStatus(err))
+		writeJSONError(w, r, err)
+		return
+	}
+	res, err := storage.NewMetaWrapper(r.URL.Query().Get(":roof")).Batch(app.Id, bs.Ops, bs.Atomic)
+	switch {
+	case errors.Is(err, storage.ErrTooManyOps):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		writeJSONError(w, r, err)
+		return
+	case errors.Is(err, storage.ErrRolledBack):
+		w.WriteHeader(http.StatusConflict)
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSONError(w, r, err)
+		return
+	}
+	var failed int
+	for _, it := range res {
+		if !it.OK {
+			failed++
+		}
+	}
+	meta := newApiMeta(err == nil)
+	meta["total"] = len(res)
+	meta["failed"] = failed
+	if err != nil {
+		meta["error"] = err.Error()
+	}
+	writeJSONQuiet(w, r, newApiRes(meta, res))
+}
+
 func tokenHandler(w http.ResponseWriter, r *http.Request) {
 	var param tokenSchema
 	if err := Bind(r, &param); err != nil {
@@ -2,9 +2,15 @@ package web
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 
+	"github.com/go-imsto/imsto/config"
 	"github.com/go-imsto/imsto/storage"
 )
 
@@ -14,14 +20,113 @@ type App = storage.App
 const (
 	DefaultMaxMemory = 12 << 20 // 8 MB
 	APIKeyHeader     = "X-Access-Key"
+
+	defaultMaxBody    = 1 << 20 // 1 MB
+	multipartOverhead = 1 << 20 // form fields and boundaries
+	maxUploadFiles    = 10      // files of a multipart upload
+	maxFetchURLs      = 20
 )
 
 type ctxKey uint16
 
 const (
 	ctxAppKey ctxKey = iota
+	ctxUploadKey
 )
 
+// limitBody 限制非上传的请求体大小
+func limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, defaultMaxBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitUpload 上传的请求体按 roof 的 max_upload 限制，multipart 最多 maxUploadFiles 个文件，
+// 在这里边读边检查每个文件，结果用 uploadFilesFromContext 取出
+func limitUpload(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := config.GetSection(r.URL.Query().Get(":roof")).UploadLimit()
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "multipart/form-data" {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit*maxUploadFiles+multipartOverhead)
+		files, err := readMultipart(r, limit)
+		if err != nil {
+			logger().Infow("read multipart fail", "uri", r.URL.Path, "err", err)
+			w.WriteHeader(errorStatus(err, bodyErrorStatus(err)))
+			writeJSONError(w, r, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxUploadKey, files)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// uploadFile multipart 中的一个文件，超出限制的只有 Err
+type uploadFile struct {
+	Key  string
+	Name string
+	MIME string
+	Data []byte
+	Err  error
+}
+
+// readMultipart 读出所有的文件和参数，参数放入 r.Form 和 r.MultipartForm，
+// 超过 limit 的文件不保留内容
+func readMultipart(r *http.Request, limit int64) (files []*uploadFile, err error) {
+	if err = r.ParseForm(); err != nil {
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return
+	}
+	values := make(map[string][]string)
+	budget := int64(defaultMaxBody)
+	for {
+		part, pe := mr.NextPart()
+		if pe == io.EOF {
+			break
+		}
+		if pe != nil {
+			return nil, pe
+		}
+		key := part.FormName()
+		if part.FileName() == "" {
+			data, re := storage.ReadLimited(part, budget)
+			if re != nil {
+				return nil, fmt.Errorf("form values: %w", re)
+			}
+			budget -= int64(len(data))
+			values[key] = append(values[key], string(data))
+			r.Form.Add(key, string(data))
+			r.PostForm.Add(key, string(data))
+			continue
+		}
+		f := &uploadFile{Key: key, Name: part.FileName(), MIME: part.Header.Get("Content-Type")}
+		if len(files) < maxUploadFiles {
+			f.Data, f.Err = storage.ReadLimited(part, limit)
+		} else {
+			f.Err = fmt.Errorf("%w: more than %d files", storage.ErrTooLarge, maxUploadFiles)
+		}
+		if f.Err != nil && !errors.Is(f.Err, storage.ErrTooLarge) {
+			return nil, f.Err
+		}
+		files = append(files, f)
+	}
+	r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+	return
+}
+
+// uploadFilesFromContext limitUpload 读出的文件
+func uploadFilesFromContext(ctx context.Context) []*uploadFile {
+	files, _ := ctx.Value(ctxUploadKey).([]*uploadFile)
+	return files
+}
+
 // CheckAPIKey ...
 func CheckAPIKey(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
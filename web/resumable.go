@@ -0,0 +1,116 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-imsto/imsto/config"
+	"github.com/go-imsto/imsto/storage"
+	"github.com/go-imsto/imsto/web/tus"
+)
+
+var (
+	tusOnce    sync.Once
+	tusHandler *tus.Handler
+	tusErr     error
+)
+
+// getTus 分片暂存在 CacheRoot/tus 下
+func getTus() (*tus.Handler, error) {
+	tusOnce.Do(func() {
+		var st *tus.Store
+		st, tusErr = tus.NewStore(path.Join(config.Current().CacheRoot, "tus"))
+		if tusErr != nil {
+			return
+		}
+		tusHandler = &tus.Handler{
+			Store: st,
+			TTL: func() time.Duration {
+				return config.Current().UploadExpire
+			},
+			MaxSize: func(roof string) int64 {
+				return config.GetSection(roof).UploadLimit()
+			},
+			Location: func(roof, id string) string {
+				return "/imsto/" + roof + "/upload/" + id
+			},
+			Authorize: tusAuthorize,
+			Owner: func(r *http.Request) string {
+				if app, ok := AppFromContext(r.Context()); ok {
+					return strconv.Itoa(int(app.Id))
+				}
+				return ""
+			},
+			Complete: tusComplete,
+		}
+	})
+	return tusHandler, tusErr
+}
+
+// tusAuthorize 与上传一样校验 token，token 可以在 Upload-Metadata 中或参数中
+func tusAuthorize(r *http.Request, info *tus.Info) error {
+	app, ok := AppFromContext(r.Context())
+	if !ok {
+		return errors.New("app error")
+	}
+	token := info.Meta["token"]
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	if _, err := app.VerifyToken(token); err != nil {
+		return err
+	}
+	info.Owner = strconv.Itoa(int(app.Id))
+	return nil
+}
+
+// tusComplete 传完的文件入库，结果与上传相同
+func tusComplete(r *http.Request, info *tus.Info, file string) (interface{}, int) {
+	app, _ := AppFromContext(r.Context())
+	name := info.Meta["filename"]
+	meta := newApiMeta(true)
+	meta["stageHost"] = config.GetSection(info.Roof).Host
+	meta["urlPrefix"] = getURL(info.Roof, "") + "/"
+	meta["version"] = config.Version
+
+	entry, err := storage.PrepareFile(info.Roof, file, name)
+	if err == nil {
+		entry.AppId = app.Id
+		entry.Author = storage.Author(atoi(info.Meta["user"]))
+		entry.Tags, _ = storage.ParseTags(info.Meta["tags"])
+		err = <-entry.Store(info.Roof)
+	}
+	if err != nil {
+		logger().Infow("resumable upload fail", "roof", info.Roof, "upload", info.ID, "name", name, "err", err)
+		if entry == nil {
+			entry = &storage.Entry{Name: name}
+		}
+		entry.Err = err.Error()
+		return newApiRes(meta, []*storage.Entry{entry}), errorStatus(err, http.StatusBadRequest)
+	}
+	logger().Infow("resumable upload stored", "roof", info.Roof, "upload", info.ID, "id", entry.Id, "path", entry.Path)
+	return newApiRes(meta, []*storage.Entry{entry}), 0
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// tusServe 取出 roof 和 upload id 交给 tus
+func tusServe(serve func(h *tus.Handler, w http.ResponseWriter, r *http.Request, roof, id string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, err := getTus()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSONError(w, r, err)
+			return
+		}
+		q := r.URL.Query()
+		serve(h, w, r, q.Get(":roof"), q.Get(":uid"))
+	}
+}
@@ -0,0 +1,277 @@
+package tus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consts
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,expiration"
+
+	OffsetContentType = "application/offset+octet-stream"
+
+	sweepInterval = time.Minute
+)
+
+// Handler tus 协议的处理，鉴权和完成后的入库由调用者提供
+type Handler struct {
+	Store *Store
+	TTL   func() time.Duration // expiration of an unfinished upload
+
+	MaxSize   func(roof string) int64
+	Location  func(roof, id string) string
+	Authorize func(r *http.Request, info *Info) error // on creation, set info.Owner
+	Owner     func(r *http.Request) string
+	Complete  func(r *http.Request, info *Info, file string) (result interface{}, status int)
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (h *Handler) header(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", Version)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (h *Handler) checkVersion(w http.ResponseWriter, r *http.Request) bool {
+	h.header(w)
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// ServeOptions 协议发现
+func (h *Handler) ServeOptions(w http.ResponseWriter, r *http.Request, roof string) {
+	h.header(w)
+	w.Header().Set("Tus-Version", Version)
+	w.Header().Set("Tus-Extension", Extensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize(roof), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeCreate POST 新建上传
+func (h *Handler) ServeCreate(w http.ResponseWriter, r *http.Request, roof string) {
+	if !h.checkVersion(w, r) {
+		return
+	}
+	h.sweep()
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if max := h.MaxSize(roof); length > max {
+		http.Error(w, fmt.Sprintf("Upload-Length %d exceeds %d", length, max), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	info := &Info{
+		Roof:    roof,
+		Length:  length,
+		Meta:    meta,
+		Created: now,
+		Expires: now.Add(h.TTL()),
+	}
+	if err = h.Authorize(r, info); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err = h.Store.Create(info); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", h.Location(roof, info.ID))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// load 取出上传并检查过期和所有者
+func (h *Handler) load(w http.ResponseWriter, r *http.Request, roof, id string) *Info {
+	info, err := h.Store.Get(id)
+	if err == nil && time.Now().After(info.Expires) {
+		h.Store.Remove(id)
+		err = ErrNotFound
+	}
+	if err == nil && (info.Roof != roof || info.Owner != h.Owner(r)) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrNotFound {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return nil
+	}
+	return info
+}
+
+func (h *Handler) status(w http.ResponseWriter, info *Info) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+}
+
+// ServeHead 查询已接收的偏移
+func (h *Handler) ServeHead(w http.ResponseWriter, r *http.Request, roof, id string) {
+	if !h.checkVersion(w, r) {
+		return
+	}
+	unlock := h.Store.Lock(id)
+	defer unlock()
+	info := h.load(w, r, roof, id)
+	if info == nil {
+		return
+	}
+	h.status(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServePatch 追加一段数据，传完后调用 Complete
+func (h *Handler) ServePatch(w http.ResponseWriter, r *http.Request, roof, id string) {
+	if !h.checkVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != OffsetContentType {
+		http.Error(w, "Content-Type must be "+OffsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	unlock := h.Store.Lock(id)
+	defer unlock()
+	info := h.load(w, r, roof, id)
+	if info == nil {
+		return
+	}
+	if offset != info.Offset {
+		h.status(w, info)
+		http.Error(w, fmt.Sprintf("offset is %d", info.Offset), http.StatusConflict)
+		return
+	}
+	if info.Done() {
+		h.status(w, info)
+		http.Error(w, "upload is finished", http.StatusForbidden)
+		return
+	}
+
+	f, err := os.OpenFile(h.Store.DataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// keep what is received even when the client is gone
+	n, cerr := io.Copy(f, io.LimitReader(r.Body, info.Length-info.Offset))
+	f.Close()
+	info.Offset += n
+	if err = h.Store.Save(info); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cerr != nil {
+		h.status(w, info)
+		http.Error(w, cerr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if info.Done() {
+		result, status := h.Complete(r, info, h.Store.DataPath(id))
+		info.Result, _ = json.Marshal(result)
+		info.Status = status
+		h.Store.RemoveData(id)
+		_ = h.Store.Save(info)
+		if status >= 400 {
+			h.status(w, info)
+			writeResult(w, info)
+			return
+		}
+	}
+	h.status(w, info)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeResult GET 完成后的结果，未完成时为 409
+func (h *Handler) ServeResult(w http.ResponseWriter, r *http.Request, roof, id string) {
+	h.header(w)
+	unlock := h.Store.Lock(id)
+	defer unlock()
+	info := h.load(w, r, roof, id)
+	if info == nil {
+		return
+	}
+	h.status(w, info)
+	if !info.Done() || len(info.Result) == 0 {
+		http.Error(w, "upload is not finished", http.StatusConflict)
+		return
+	}
+	writeResult(w, info)
+}
+
+func writeResult(w http.ResponseWriter, info *Info) {
+	status := info.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(info.Result)
+}
+
+func (h *Handler) sweep() {
+	h.mu.Lock()
+	now := time.Now()
+	if now.Sub(h.lastSweep) < sweepInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastSweep = now
+	h.mu.Unlock()
+	go h.Store.Sweep(now)
+}
+
+// ParseMetadata 解析 Upload-Metadata: key base64,key2 base64
+func ParseMetadata(s string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, " ")
+		if k == "" {
+			return nil, fmt.Errorf("invalid Upload-Metadata %q", pair)
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %s: %s", k, err)
+		}
+		meta[k] = string(b)
+	}
+	return meta, nil
+}
@@ -0,0 +1,213 @@
+package tus
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *httptest.Server) {
+	st, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	h := &Handler{
+		Store:    st,
+		TTL:      func() time.Duration { return time.Hour },
+		MaxSize:  func(string) int64 { return 100 },
+		Location: func(roof, id string) string { return "/imsto/" + roof + "/upload/" + id },
+		Authorize: func(r *http.Request, info *Info) error {
+			if info.Meta["token"] != "ok" {
+				return errors.New("bad token")
+			}
+			info.Owner = r.Header.Get("X-Owner")
+			return nil
+		},
+		Owner: func(r *http.Request) string { return r.Header.Get("X-Owner") },
+		Complete: func(r *http.Request, info *Info, file string) (interface{}, int) {
+			b, _ := os.ReadFile(file)
+			if string(b) == "bad!" {
+				return map[string]string{"error": "bad"}, http.StatusUnsupportedMediaType
+			}
+			return map[string]string{"data": string(b), "name": info.Meta["filename"]}, 0
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/imsto/demo/upload", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			h.ServeOptions(w, r, "demo")
+		case http.MethodPost:
+			h.ServeCreate(w, r, "demo")
+		}
+	})
+	mux.HandleFunc("/imsto/demo/upload/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/imsto/demo/upload/")
+		switch r.Method {
+		case http.MethodHead:
+			h.ServeHead(w, r, "demo", id)
+		case http.MethodPatch:
+			h.ServePatch(w, r, "demo", id)
+		case http.MethodGet:
+			h.ServeResult(w, r, "demo", id)
+		}
+	})
+	return h, httptest.NewServer(mux)
+}
+
+func do(t *testing.T, method, url string, body string, header map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Tus-Resumable", Version)
+	req.Header.Set("X-Owner", "7")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	return res
+}
+
+func patch(t *testing.T, url string, offset int, body string) *http.Response {
+	return do(t, http.MethodPatch, url, body, map[string]string{
+		"Content-Type":  OffsetContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func create(t *testing.T, ts *httptest.Server, length int) *http.Response {
+	return do(t, http.MethodPost, ts.URL+"/imsto/demo/upload", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename YS5qcGc=,token b2s=",
+	})
+}
+
+func TestUpload(t *testing.T) {
+	_, ts := newTestHandler(t)
+	defer ts.Close()
+
+	res := do(t, http.MethodOptions, ts.URL+"/imsto/demo/upload", "", nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "100", res.Header.Get("Tus-Max-Size"))
+	assert.Equal(t, Extensions, res.Header.Get("Tus-Extension"))
+
+	res = do(t, http.MethodPost, ts.URL+"/imsto/demo/upload", "", map[string]string{"Upload-Length": "10", "Tus-Resumable": "0.2.2"})
+	assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+	res = create(t, ts, 101)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	res = do(t, http.MethodPost, ts.URL+"/imsto/demo/upload", "", map[string]string{"Upload-Length": "10"})
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = create(t, ts, 10)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Upload-Expires"))
+	url := ts.URL + res.Header.Get("Location")
+
+	res = patch(t, url, 0, "hello")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "5", res.Header.Get("Upload-Offset"))
+
+	res = do(t, http.MethodGet, url, "", nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	// resume after a lost response
+	res = patch(t, url, 0, "hello")
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	res = do(t, http.MethodHead, url, "", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "5", res.Header.Get("Upload-Offset"))
+	assert.Equal(t, "10", res.Header.Get("Upload-Length"))
+
+	// other owner can not see it
+	res = do(t, http.MethodHead, url, "", map[string]string{"X-Owner": "8"})
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = do(t, http.MethodPatch, url, "world", map[string]string{"Upload-Offset": "5"})
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+
+	res = patch(t, url, 5, "world")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "10", res.Header.Get("Upload-Offset"))
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-Owner", "7")
+	gr, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(gr.Body)
+	gr.Body.Close()
+	assert.Equal(t, http.StatusOK, gr.StatusCode)
+	assert.JSONEq(t, `{"data":"helloworld","name":"a.jpg"}`, string(b))
+
+	res = patch(t, url, 10, "x")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// failed completion
+	res = create(t, ts, 4)
+	url = ts.URL + res.Header.Get("Location")
+	res = patch(t, url, 0, "bad!")
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+
+	res = do(t, http.MethodHead, ts.URL+"/imsto/demo/upload/../../etc", "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestExpire(t *testing.T) {
+	h, ts := newTestHandler(t)
+	defer ts.Close()
+
+	res := create(t, ts, 10)
+	url := ts.URL + res.Header.Get("Location")
+	id := res.Header.Get("Location")[len("/imsto/demo/upload/"):]
+
+	info, err := h.Store.Get(id)
+	assert.NoError(t, err)
+	info.Expires = time.Now().Add(-time.Second)
+	assert.NoError(t, h.Store.Save(info))
+	res = do(t, http.MethodHead, url, "", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	_, err = os.Stat(h.Store.DataPath(id))
+	assert.True(t, os.IsNotExist(err))
+
+	res = create(t, ts, 10)
+	id = res.Header.Get("Location")[len("/imsto/demo/upload/"):]
+	assert.Equal(t, 0, h.Store.Sweep(time.Now()))
+	assert.Equal(t, 1, h.Store.Sweep(time.Now().Add(2*time.Hour)))
+	_, err = h.Store.Get(id)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLock(t *testing.T) {
+	st, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	id := newID()
+	unlock := st.Lock(id)
+	done := make(chan struct{})
+	go func() {
+		st.Lock(id)()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+	st.Lock("../../etc")()
+	assert.Empty(t, st.locks)
+}
+
+func TestParseMetadata(t *testing.T) {
+	meta, err := ParseMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "world_domination_plan.pdf", "is_confidential": ""}, meta)
+	_, err = ParseMetadata("filename !!!")
+	assert.Error(t, err)
+}
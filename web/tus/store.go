@@ -0,0 +1,173 @@
+// Package tus 可续传上传，实现 tus 1.0 核心协议以及 creation, expiration 扩展
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errors
+var (
+	ErrNotFound = errors.New("upload not found")
+)
+
+var idRE = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Info 一个上传的状态，与数据文件一起保存在目录中
+type Info struct {
+	ID      string            `json:"id"`
+	Roof    string            `json:"roof"`
+	Owner   string            `json:"owner"`
+	Length  int64             `json:"length"`
+	Offset  int64             `json:"offset"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Created time.Time         `json:"created"`
+	Expires time.Time         `json:"expires"`
+
+	Result json.RawMessage `json:"result,omitempty"` // of completion
+	Status int             `json:"status,omitempty"` // of completion, 0 is OK
+}
+
+// Done 数据是否已经传完
+func (i *Info) Done() bool {
+	return i.Offset >= i.Length
+}
+
+// Store 上传的分片暂存目录
+type Store struct {
+	Dir string
+
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+// uploadLock 一个上传的锁，refs 为持有和等待的数量
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewStore ...
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir, locks: make(map[string]*uploadLock)}, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.Dir, id+".info")
+}
+
+// DataPath 数据文件的路径
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.Dir, id+".bin")
+}
+
+// Lock 锁定一个上传，返回解锁函数，最后一个解锁时删除锁，
+// 无效的 id 不会有上传，不加锁
+func (s *Store) Lock(id string) func() {
+	if !idRE.MatchString(id) {
+		return func() {}
+	}
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = new(uploadLock)
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Create 新建一个空的上传，info.ID 会被设置
+func (s *Store) Create(info *Info) error {
+	info.ID = newID()
+	f, err := os.OpenFile(s.DataPath(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return s.Save(info)
+}
+
+// Get ...
+func (s *Store) Get(id string) (*Info, error) {
+	if !idRE.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	b, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	info := new(Info)
+	if err = json.Unmarshal(b, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Save 先写临时文件再改名，中断时不会留下半个 info
+func (s *Store) Save(info *Info) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.ID))
+}
+
+// RemoveData 完成后只删除数据，保留结果到过期
+func (s *Store) RemoveData(id string) {
+	_ = os.Remove(s.DataPath(id))
+}
+
+// Remove ...
+func (s *Store) Remove(id string) {
+	_ = os.Remove(s.DataPath(id))
+	_ = os.Remove(s.infoPath(id))
+}
+
+// Sweep 删除过期的上传，返回删除的数量
+func (s *Store) Sweep(now time.Time) (n int) {
+	names, _ := filepath.Glob(filepath.Join(s.Dir, "*.info"))
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), ".info")
+		unlock := s.Lock(id)
+		if info, err := s.Get(id); err == nil && now.After(info.Expires) {
+			s.Remove(id)
+			n++
+		}
+		unlock()
+	}
+	return
+}